@@ -0,0 +1,139 @@
+package goHystrix
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultExportQueueSize is the size of the queue of the exporters set with SetExporter
+	DefaultExportQueueSize = 10000
+)
+
+// AsyncExport puts a bounded queue in front of an exporter, the metrics are exported
+// in its own goroutine so a slow exporter never blocks the goroutines of the metrics.
+// When the queue is full the metrics are dropped and counted in Dropped.
+type AsyncExport struct {
+	export MetricExport
+
+	mutex   sync.RWMutex
+	closed  bool
+	events  chan func(MetricExport)
+	stopped chan struct{}
+	dropped int64
+}
+
+// NewAsyncExport starts the goroutine that exports the metrics, Close stops it
+func NewAsyncExport(export MetricExport, size int) *AsyncExport {
+	a := &AsyncExport{
+		export:  export,
+		events:  make(chan func(MetricExport), size),
+		stopped: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncExport) run() {
+	defer close(a.stopped)
+	for event := range a.events {
+		event(a.export)
+	}
+}
+
+// send queues the event, or drops it if the queue is full or the exporter is closed
+func (a *AsyncExport) send(event func(MetricExport)) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		atomic.AddInt64(&a.dropped, 1)
+		return
+	}
+	select {
+	case a.events <- event:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+// Dropped returns the number of metrics dropped because the queue was full
+func (a *AsyncExport) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Export returns the exporter behind the queue
+func (a *AsyncExport) Export() MetricExport {
+	return a.export
+}
+
+// Close exports the metrics in the queue, stops the goroutine and closes the exporter if it is an io.Closer
+func (a *AsyncExport) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.events)
+	a.mutex.Unlock()
+
+	<-a.stopped
+	if closer, ok := a.export.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (a *AsyncExport) Success(group string, name string, duration time.Duration) {
+	a.send(func(e MetricExport) { e.Success(group, name, duration) })
+}
+
+func (a *AsyncExport) Fail(group string, name string) {
+	a.send(func(e MetricExport) { e.Fail(group, name) })
+}
+
+func (a *AsyncExport) Fallback(group string, name string) {
+	a.send(func(e MetricExport) { e.Fallback(group, name) })
+}
+
+func (a *AsyncExport) FallbackError(group string, name string) {
+	a.send(func(e MetricExport) { e.FallbackError(group, name) })
+}
+
+func (a *AsyncExport) Timeout(group string, name string) {
+	a.send(func(e MetricExport) { e.Timeout(group, name) })
+}
+
+func (a *AsyncExport) Panic(group string, name string) {
+	a.send(func(e MetricExport) { e.Panic(group, name) })
+}
+
+func (a *AsyncExport) Hedge(group string, name string) {
+	a.send(func(e MetricExport) { e.Hedge(group, name) })
+}
+
+func (a *AsyncExport) Throttled(group string, name string) {
+	a.send(func(e MetricExport) { e.Throttled(group, name) })
+}
+
+func (a *AsyncExport) Rejected(group string, name string) {
+	a.send(func(e MetricExport) { e.Rejected(group, name) })
+}
+
+func (a *AsyncExport) FailWithError(group string, name string, err error) {
+	a.send(func(e MetricExport) { exportFail(e, group, name, err) })
+}
+
+func (a *AsyncExport) TimeoutWithError(group string, name string, err error) {
+	a.send(func(e MetricExport) { exportTimeout(e, group, name, err) })
+}
+
+func (a *AsyncExport) RejectedWithError(group string, name string, err error) {
+	a.send(func(e MetricExport) { exportRejected(e, group, name, err) })
+}
+
+func (a *AsyncExport) State(circuits *CircuitHolder) {
+	a.send(func(e MetricExport) { e.State(circuits) })
+}
@@ -0,0 +1,137 @@
+package goHystrix
+
+import (
+	"context"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordExport keeps the events, it blocks while block is not closed
+type recordExport struct {
+	mutex  sync.Mutex
+	events []string
+	block  chan struct{}
+	closed bool
+}
+
+func newRecordExport() *recordExport {
+	block := make(chan struct{})
+	close(block)
+	return &recordExport{block: block}
+}
+
+func (r *recordExport) record(event string, group string, name string) {
+	<-r.block
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s %s.%s", event, group, name))
+}
+
+func (r *recordExport) Events() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func (r *recordExport) Success(group string, name string, duration time.Duration) {
+	r.record("success", group, name)
+}
+func (r *recordExport) Fail(group string, name string)     { r.record("fail", group, name) }
+func (r *recordExport) Fallback(group string, name string) { r.record("fallback", group, name) }
+func (r *recordExport) FallbackError(group string, name string) {
+	r.record("fallbackError", group, name)
+}
+func (r *recordExport) Timeout(group string, name string)   { r.record("timeout", group, name) }
+func (r *recordExport) Panic(group string, name string)     { r.record("panic", group, name) }
+func (r *recordExport) Hedge(group string, name string)     { r.record("hedge", group, name) }
+func (r *recordExport) Throttled(group string, name string) { r.record("throttled", group, name) }
+func (r *recordExport) Rejected(group string, name string)  { r.record("rejected", group, name) }
+func (r *recordExport) State(circuits *CircuitHolder)       { r.record("state", "", "") }
+
+func (r *recordExport) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	return nil
+}
+
+func TestAsyncExport(t *testing.T) {
+	Convey("AsyncExport exports the metrics in its own goroutine", t, func() {
+		record := newRecordExport()
+		record.block = make(chan struct{})
+		async := NewAsyncExport(record, 2)
+
+		Convey("A blocked exporter doesn't block the caller, the metrics over the queue are dropped", func() {
+			done := make(chan struct{})
+			go func() {
+				for i := 0; i < 10; i++ {
+					async.Fail("group", "name")
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				So("blocked", ShouldBeNil)
+			}
+			// one in the exporter and two in the queue
+			So(async.Dropped(), ShouldBeGreaterThanOrEqualTo, 7)
+
+			close(record.block)
+			So(async.Close(), ShouldBeNil)
+			So(len(record.Events())+int(async.Dropped()), ShouldEqual, 10)
+		})
+
+		Convey("Close exports the metrics in the queue and closes the exporter", func() {
+			async.Success("group", "name", time.Millisecond)
+			async.Timeout("group", "name")
+			close(record.block)
+			So(async.Close(), ShouldBeNil)
+
+			So(record.Events(), ShouldResemble, []string{"success group.name", "timeout group.name"})
+			So(record.closed, ShouldBeTrue)
+			So(async.Dropped(), ShouldEqual, 0)
+
+			Convey("The metrics after Close are dropped", func() {
+				async.Fail("group", "name")
+				So(async.Dropped(), ShouldEqual, 1)
+				So(async.Close(), ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("SetExporter puts the exporter behind an AsyncExport", t, func() {
+		defer SetExporter(NewNilExport())
+		record := newRecordExport()
+		SetExporter(record)
+		async, ok := Exporter().(*AsyncExport)
+		So(ok, ShouldBeTrue)
+		So(async.Export(), ShouldEqual, record)
+
+		SetExporter(NewNilExport())
+		_, ok = Exporter().(NilExport)
+		So(ok, ShouldBeTrue)
+
+		// the previous exporter is closed
+		So(record.closed, ShouldBeTrue)
+		So(async.Close(), ShouldBeNil)
+	})
+
+	Convey("The metrics of the commands go through the queue", t, func() {
+		CircuitsReset()
+		defer SetExporter(NewNilExport())
+		record := newRecordExport()
+		SetExporter(record)
+
+		command := MustNewCommand("asyncCmd", "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+
+		So(Circuits().Shutdown(context.Background()), ShouldBeNil)
+		So(record.Events(), ShouldResemble, []string{"success testGroup.asyncCmd"})
+		So(record.closed, ShouldBeTrue)
+	})
+}
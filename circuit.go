@@ -2,8 +2,15 @@ package goHystrix
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type CircuitBreaker struct {
@@ -13,10 +20,28 @@ type CircuitBreaker struct {
 	metric              *Metric
 	errorsThreshold     float64
 	minRequestThreshold int64
+	timeout             time.Duration
+	adaptiveTimeout     AdaptiveTimeoutOptions
+
+	// concurrency counts the calls in flight
+	concurrency *concurrencyLimiter
+	// rateLimiter is the rate limit of the calls, nil is unlimited
+	rateLimiter *tokenBucket
+	// queued are the calls waiting for a token of the rate limit
+	queued int64
 }
 
 var (
 	circuits = NewCircuitsHolder()
+
+	// ErrCircuitOpen is the error for the calls that are not executed because the circuit is open
+	ErrCircuitOpen = errors.New("circuit open")
+
+	// ErrMaxConcurrency is the error for the calls rejected because MaxConcurrentRequests are in flight
+	ErrMaxConcurrency = errors.New("max concurrent requests reached")
+
+	// ErrThrottled is the error for the calls rejected by the rate limit
+	ErrThrottled = errors.New("rate limit exceeded")
 )
 
 type CircuitHolder struct {
@@ -28,23 +53,49 @@ func NewCircuitNoParams(group string, name string) *CircuitBreaker {
 	return NewCircuit(group, name, CommandOptionsDefaults())
 }
 
+// NewCircuit returns the circuit of the group and name, it creates it with the options if it doesn't exist.
+// If the options are not valid it logs the error and uses CommandOptionsDefaults().
 func NewCircuit(group string, name string, options CommandOptions) *CircuitBreaker {
+	c, err := NewCircuitWithOptions(group, name, options)
+	if err != nil {
+		logEvent(slog.LevelError, "circuit.invalid_options", "goHystrix: "+group+"/"+name+": "+err.Error()+", using the default options",
+			"group", group, "name", name, "error", err)
+		c, _ = NewCircuitWithOptions(group, name, CommandOptionsDefaults())
+	}
+	return c
+}
+
+// NewCircuitWithOptions is like NewCircuit but it returns an error if the options are not valid
+func NewCircuitWithOptions(group string, name string, options CommandOptions) (*CircuitBreaker, error) {
 	c, ok := Circuits().Get(group, name)
 	if ok {
-		return c
+		return c, nil
 	}
-	metric := NewMetricWithParams(group, name, options.NumberOfSecondsToStore, options.NumberOfSamplesToStore)
+	options = options.mergeDefaults()
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	metric := NewMetricWithSample(group, name, options.NumberOfSecondsToStore, options.latencySample())
 	c = &CircuitBreaker{
 		name:                name,
 		group:               group,
 		metric:              metric,
 		errorsThreshold:     options.ErrorsThreshold,
 		minRequestThreshold: options.MinimumNumberOfRequest,
+		timeout:             options.Timeout,
+		adaptiveTimeout:     options.AdaptiveTimeout,
+		concurrency:         newConcurrencyLimiter(options),
+	}
+	if options.RateLimit.Rate > 0 {
+		c.rateLimiter = newTokenBucket(options.RateLimit)
 	}
 
-	Circuits().Set(group, name, c)
-	return c
-
+	// a concurrent call could have created the circuit, the first one wins
+	if existing, ok := Circuits().setIfAbsent(group, name, c); !ok {
+		metric.Stop()
+		return existing, nil
+	}
+	return c, nil
 }
 
 func (c *CircuitBreaker) IsOpen() (bool, string) {
@@ -60,6 +111,53 @@ func (c *CircuitBreaker) IsOpen() (bool, string) {
 	return false, "CLOSE: all ok"
 }
 
+// Acquire takes a slot for a call, it returns false if the concurrency limit is reached
+// (MaxConcurrentRequests or the adaptive limit).
+// Every successful Acquire needs a Release or a ReleaseWithLatency when the call finishes.
+func (c *CircuitBreaker) Acquire() bool {
+	return c.concurrency.acquire()
+}
+
+// Allow takes a token of the rate limit, waiting up to RateLimit.MaxWait,
+// it returns false if the call is throttled. It is checked before IsOpen and Acquire.
+func (c *CircuitBreaker) Allow() bool {
+	if c.rateLimiter == nil {
+		return true
+	}
+	wait, ok := c.rateLimiter.reserve(time.Now())
+	if ok && wait > 0 {
+		atomic.AddInt64(&c.queued, 1)
+		time.Sleep(wait)
+		atomic.AddInt64(&c.queued, -1)
+	}
+	return ok
+}
+
+func (c *CircuitBreaker) Release() {
+	c.concurrency.release()
+}
+
+// ReleaseWithLatency releases the slot and adjusts the adaptive concurrency limit with
+// the latency of the call, timedOut calls always decrease the limit
+func (c *CircuitBreaker) ReleaseWithLatency(latency time.Duration, timedOut bool) {
+	c.concurrency.releaseWithLatency(latency, timedOut)
+}
+
+// ConcurrencyLimit returns the current concurrency limit (0 is unlimited) and the calls in flight
+func (c *CircuitBreaker) ConcurrencyLimit() (limit int, inFlight int) {
+	return c.concurrency.state()
+}
+
+// QueueDepth returns the calls waiting for the rate limit
+func (c *CircuitBreaker) QueueDepth() int {
+	return int(atomic.LoadInt64(&c.queued))
+}
+
+// Timeout returns the effective timeout for the options of the circuit, the adaptive one if it is set
+func (c *CircuitBreaker) Timeout() time.Duration {
+	return c.adaptiveTimeout.timeout(c.timeout, c.metric.Stats())
+}
+
 func (c *CircuitBreaker) Metric() *Metric {
 	return c.metric
 }
@@ -82,6 +180,12 @@ func (c *CircuitBreaker) ToJSON() string {
 
 	fmt.Fprintf(&buffer, "\"isOpen\" : \"%t\",\n", open)
 	fmt.Fprintf(&buffer, "\"state\" : \"%s\",\n", state)
+	fmt.Fprintf(&buffer, "\"timeout\" : \"%s\",\n", c.Timeout())
+
+	limit, inFlight := c.ConcurrencyLimit()
+	fmt.Fprintf(&buffer, "\"concurrencyLimit\" : \"%d\",\n", limit)
+	fmt.Fprintf(&buffer, "\"inFlight\" : \"%d\",\n", inFlight)
+	fmt.Fprintf(&buffer, "\"queued\" : \"%d\",\n", c.QueueDepth())
 
 	fmt.Fprintf(&buffer, "\"percentile90\" : \"%f\",\n", stats.Percentile(0.90))
 	fmt.Fprintf(&buffer, "\"mean\" : \"%f\",\n", stats.Mean())
@@ -94,6 +198,9 @@ func (c *CircuitBreaker) ToJSON() string {
 	fmt.Fprintf(&buffer, "\"timeouts\" : \"%d\",\n", counts.Timeouts)
 	fmt.Fprintf(&buffer, "\"fallback\" : \"%d\",\n", counts.Fallback)
 	fmt.Fprintf(&buffer, "\"panics\" : \"%d\",\n", counts.Panics)
+	fmt.Fprintf(&buffer, "\"hedges\" : \"%d\",\n", counts.Hedges)
+	fmt.Fprintf(&buffer, "\"throttled\" : \"%d\",\n", counts.Throttled)
+	fmt.Fprintf(&buffer, "\"rejected\" : \"%d\",\n", counts.Rejected)
 	fmt.Fprintf(&buffer, "\"fallbackErrors\" : \"%d\",\n", counts.FallbackErrors)
 	fmt.Fprintf(&buffer, "\"total\" : \"%d\",\n", counts.Total)
 	fmt.Fprintf(&buffer, "\"success\" : \"%d\",\n", counts.Success)
@@ -144,6 +251,94 @@ func (holder *CircuitHolder) Set(group string, name string, value *CircuitBreake
 	circuitsValues[name] = value
 }
 
+// setIfAbsent stores the circuit if there is none for the group and name,
+// it returns the stored circuit and false if it was already there
+func (holder *CircuitHolder) setIfAbsent(group string, name string, value *CircuitBreaker) (*CircuitBreaker, bool) {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+
+	circuitsValues, ok := holder.circuits[group]
+	if !ok {
+		circuitsValues = make(map[string]*CircuitBreaker)
+		holder.circuits[group] = circuitsValues
+	}
+	if existing, ok := circuitsValues[name]; ok {
+		return existing, false
+	}
+	circuitsValues[name] = value
+	return value, true
+}
+
+// sortedCircuits returns the circuits sorted by group and name
+func (holder *CircuitHolder) sortedCircuits() []*CircuitBreaker {
+	holder.mutex.RLock()
+	circuits := make([]*CircuitBreaker, 0)
+	for _, names := range holder.circuits {
+		for _, circuit := range names {
+			circuits = append(circuits, circuit)
+		}
+	}
+	holder.mutex.RUnlock()
+
+	sort.Slice(circuits, func(i, j int) bool {
+		if circuits[i].group != circuits[j].group {
+			return circuits[i].group < circuits[j].group
+		}
+		return circuits[i].name < circuits[j].name
+	})
+	return circuits
+}
+
+// Shutdown waits until the calls in flight finish or the context is done, then it stops the goroutines
+// of the metrics and the poller of PollState, and closes the exporter if it is an io.Closer (flushing its metrics).
+// It returns the error of the context if the calls didn't finish in time.
+func (holder *CircuitHolder) Shutdown(ctx context.Context) error {
+	err := holder.waitInFlight(ctx)
+
+	holder.mutex.RLock()
+	for _, names := range holder.circuits {
+		for _, circuit := range names {
+			circuit.metric.Stop()
+		}
+	}
+	holder.mutex.RUnlock()
+
+	PollState(holder, 0)
+	if closer, ok := Exporter().(io.Closer); ok {
+		closeErr := closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (holder *CircuitHolder) waitInFlight(ctx context.Context) error {
+	for {
+		if holder.inFlight() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (holder *CircuitHolder) inFlight() int {
+	holder.mutex.RLock()
+	defer holder.mutex.RUnlock()
+	total := 0
+	for _, names := range holder.circuits {
+		for _, circuit := range names {
+			_, inFlight := circuit.ConcurrencyLimit()
+			total += inFlight
+		}
+	}
+	return total
+}
+
 func (holder *CircuitHolder) ToJSON() string {
 	holder.mutex.RLock()
 	defer holder.mutex.RUnlock()
@@ -1,9 +1,15 @@
 package goHystrix
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"github.com/dahernan/goHystrix/statsd"
 	. "github.com/smartystreets/goconvey/convey"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCircuitsHolder(t *testing.T) {
@@ -93,4 +99,152 @@ func TestNewCircuit(t *testing.T) {
 
 	})
 
+	Convey("Concurrent calls get the same circuit", t, func() {
+		CircuitsReset()
+
+		var wg sync.WaitGroup
+		circuits := make([]*CircuitBreaker, 10)
+		for i := range circuits {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				circuits[i] = NewCircuitNoParams("testGroup", "concurrentKey")
+			}(i)
+		}
+		wg.Wait()
+
+		value, _ := Circuits().Get("testGroup", "concurrentKey")
+		for _, circuit := range circuits {
+			So(circuit, ShouldEqual, value)
+		}
+	})
+}
+
+func TestNewCircuitInvalidOptions(t *testing.T) {
+	Convey("Circuits are not created with invalid options", t, func() {
+		CircuitsReset()
+
+		Convey("NewCircuitWithOptions returns the error", func() {
+			circuit, err := NewCircuitWithOptions("testGroup", "invalidCircuit", CommandOptions{NumberOfSecondsToStore: -1})
+			So(err, ShouldNotBeNil)
+			So(circuit, ShouldBeNil)
+			_, ok := Circuits().Get("testGroup", "invalidCircuit")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("NewCircuit uses the default options", func() {
+			circuit := NewCircuit("testGroup", "invalidCircuit", CommandOptions{NumberOfSecondsToStore: -1, Timeout: time.Second})
+			So(circuit, ShouldNotBeNil)
+			So(circuit.timeout, ShouldEqual, CommandOptionsDefaults().Timeout)
+			circuit.Metric().Fail()
+			So(circuit.Metric().HealthCounts().Failures, ShouldEqual, 1)
+		})
+	})
+}
+
+// waitGoroutines waits until the number of goroutines is at most n, it returns the last count
+func waitGoroutines(n int) int {
+	var current int
+	for i := 0; i < 100; i++ {
+		current = runtime.NumGoroutine()
+		if current <= n {
+			return current
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return current
+}
+
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestShutdown(t *testing.T) {
+	Convey("Shutdown stops the goroutines of the metrics", t, func() {
+		CircuitsReset()
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 5; i++ {
+			command := MustNewCommand(fmt.Sprintf("shutdownCmd%d", i), "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+			_, err := command.Execute()
+			So(err, ShouldBeNil)
+		}
+		So(runtime.NumGoroutine(), ShouldBeGreaterThan, before)
+
+		So(Circuits().Shutdown(context.Background()), ShouldBeNil)
+		So(waitGoroutines(before), ShouldBeLessThanOrEqualTo, before)
+
+		Convey("The metrics discard the events after the shutdown", func() {
+			circuit, _ := Circuits().Get("testGroup", "shutdownCmd0")
+			circuit.Metric().Success(time.Millisecond)
+			circuit.Metric().Fail()
+			So(circuit.Metric().HealthCounts().Success, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Shutdown waits for the calls in flight", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = time.Second
+		blocking := &BlockingCommand{make(chan struct{})}
+		command := MustNewCommand("blockingCmd", "testGroup", blocking, options)
+		valueChan, _ := command.Queue()
+		for {
+			if _, inFlight := command.circuit.ConcurrencyLimit(); inFlight == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		Convey("Until the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			So(Circuits().Shutdown(ctx), ShouldEqual, context.DeadlineExceeded)
+			close(blocking.release)
+			So(<-valueChan, ShouldEqual, "done")
+		})
+
+		Convey("Until they finish", func() {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				close(blocking.release)
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			So(Circuits().Shutdown(ctx), ShouldBeNil)
+			So(<-valueChan, ShouldEqual, "done")
+		})
+	})
+
+	Convey("Shutdown stops the statsd poller and flushes the metrics", t, func() {
+		CircuitsReset()
+		before := runtime.NumGoroutine()
+
+		buffer := &syncBuffer{}
+		client, _ := statsd.New(buffer)
+		SetExporter(NewStatsdExport(client, "prefix"))
+		defer SetExporter(NewNilExport())
+		PollState(Circuits(), time.Millisecond)
+
+		command := MustNewCommand("statsdCmd", "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+
+		So(Circuits().Shutdown(context.Background()), ShouldBeNil)
+		So(waitGoroutines(before), ShouldBeLessThanOrEqualTo, before)
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.statsdCmd.success:1|c")
+	})
 }
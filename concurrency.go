@@ -0,0 +1,103 @@
+package goHystrix
+
+import (
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter counts the calls in flight and rejects the calls over the limit.
+// The limit is MaxConcurrentRequests (0 is unlimited), or an AIMD limit with AdaptiveConcurrency.
+type concurrencyLimiter struct {
+	adaptive AdaptiveConcurrencyOptions
+	window   time.Duration
+
+	mutex        sync.Mutex
+	limit        float64
+	inFlight     int
+	minLatency   time.Duration
+	minLatencyAt time.Time
+}
+
+func newConcurrencyLimiter(options CommandOptions) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		limit:  float64(options.MaxConcurrentRequests),
+		window: time.Duration(options.NumberOfSecondsToStore) * time.Second,
+	}
+	if options.AdaptiveConcurrency.InitialLimit > 0 {
+		l.adaptive = options.AdaptiveConcurrency.mergeDefaults()
+		l.limit = float64(l.adaptive.InitialLimit)
+	}
+	return l
+}
+
+func (l *concurrencyLimiter) acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limit > 0 && l.inFlight >= int(l.limit) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inFlight--
+}
+
+// releaseWithLatency releases the slot and adjusts the adaptive limit with the latency of the call
+func (l *concurrencyLimiter) releaseWithLatency(latency time.Duration, timedOut bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	inFlight := l.inFlight
+	l.inFlight--
+
+	if l.adaptive.InitialLimit == 0 {
+		return
+	}
+
+	// the minimum latency is renewed every window, so it follows the changes of the dependency
+	now := time.Now()
+	if l.minLatency == 0 || latency < l.minLatency || now.Sub(l.minLatencyAt) > l.window {
+		l.minLatency = latency
+		l.minLatencyAt = now
+	}
+
+	if timedOut || float64(latency) > float64(l.minLatency)*l.adaptive.Tolerance {
+		l.limit = l.limit * l.adaptive.Backoff
+	} else if float64(inFlight)*2 >= l.limit {
+		// only grows when the calls use the limit
+		l.limit++
+	}
+
+	if l.limit < float64(l.adaptive.MinLimit) {
+		l.limit = float64(l.adaptive.MinLimit)
+	}
+	if l.limit > float64(l.adaptive.MaxLimit) {
+		l.limit = float64(l.adaptive.MaxLimit)
+	}
+}
+
+// state returns the current limit (0 is unlimited) and the calls in flight
+func (l *concurrencyLimiter) state() (int, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit), l.inFlight
+}
+
+func (a AdaptiveConcurrencyOptions) mergeDefaults() AdaptiveConcurrencyOptions {
+	if a.MinLimit == 0 {
+		a.MinLimit = 1
+	}
+	if a.MaxLimit == 0 {
+		a.MaxLimit = 1000
+	}
+	if a.Tolerance == 0 {
+		a.Tolerance = 2
+	}
+	if a.Backoff == 0 {
+		a.Backoff = 0.9
+	}
+	return a
+}
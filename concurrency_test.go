@@ -0,0 +1,112 @@
+package goHystrix
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	Convey("Static limit is MaxConcurrentRequests", t, func() {
+		limiter := newConcurrencyLimiter(CommandOptions{MaxConcurrentRequests: 2, NumberOfSecondsToStore: 10})
+		So(limiter.acquire(), ShouldBeTrue)
+		So(limiter.acquire(), ShouldBeTrue)
+		So(limiter.acquire(), ShouldBeFalse)
+
+		limiter.releaseWithLatency(time.Second, true)
+		limit, inFlight := limiter.state()
+		So(limit, ShouldEqual, 2)
+		So(inFlight, ShouldEqual, 1)
+	})
+
+	Convey("Zero is unlimited", t, func() {
+		limiter := newConcurrencyLimiter(CommandOptions{NumberOfSecondsToStore: 10})
+		for i := 0; i < 100; i++ {
+			So(limiter.acquire(), ShouldBeTrue)
+		}
+		_, inFlight := limiter.state()
+		So(inFlight, ShouldEqual, 100)
+	})
+
+	Convey("Adaptive limit", t, func() {
+		limiter := newConcurrencyLimiter(CommandOptions{
+			NumberOfSecondsToStore: 10,
+			AdaptiveConcurrency:    AdaptiveConcurrencyOptions{InitialLimit: 4, MinLimit: 2, MaxLimit: 6, Backoff: 0.5},
+		})
+
+		run := func(calls int, latency time.Duration, timedOut bool) {
+			for i := 0; i < calls; i++ {
+				So(limiter.acquire(), ShouldBeTrue)
+			}
+			for i := 0; i < calls; i++ {
+				limiter.releaseWithLatency(latency, timedOut)
+			}
+		}
+
+		Convey("grows while the latency stays near the minimum and the limit is used", func() {
+			run(2, 10*time.Millisecond, false)
+			limit, _ := limiter.state()
+			So(limit, ShouldEqual, 5)
+
+			run(4, 10*time.Millisecond, false)
+			limit, inFlight := limiter.state()
+			So(limit, ShouldEqual, 6)
+			So(inFlight, ShouldEqual, 0)
+		})
+
+		Convey("doesn't grow if the limit is not used", func() {
+			run(1, 10*time.Millisecond, false)
+			run(1, 10*time.Millisecond, false)
+			limit, _ := limiter.state()
+			So(limit, ShouldEqual, 4)
+		})
+
+		Convey("shrinks when the latency rises", func() {
+			run(1, 10*time.Millisecond, false)
+			run(1, 50*time.Millisecond, false)
+			limit, _ := limiter.state()
+			So(limit, ShouldEqual, 2)
+		})
+
+		Convey("shrinks when the calls time out, but not under MinLimit", func() {
+			run(1, 10*time.Millisecond, true)
+			run(1, 10*time.Millisecond, true)
+			limit, _ := limiter.state()
+			So(limit, ShouldEqual, 2)
+			So(limiter.acquire(), ShouldBeTrue)
+			So(limiter.acquire(), ShouldBeTrue)
+			So(limiter.acquire(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestAdaptiveConcurrencyCommand(t *testing.T) {
+	Convey("Commands over the adaptive limit are rejected", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = time.Second
+		options.AdaptiveConcurrency = AdaptiveConcurrencyOptions{InitialLimit: 1}
+		blocking := &BlockingCommand{make(chan struct{})}
+		command := MustNewCommand("adaptiveConcurrencyCmd", "testGroup", blocking, options)
+
+		valueChan, _ := command.Queue()
+		for {
+			if _, inFlight := command.circuit.ConcurrencyLimit(); inFlight == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		_, err := command.Execute()
+		So(err, ShouldNotBeNil)
+		So(command.HealthCounts().Rejected, ShouldEqual, 1)
+
+		json := command.circuit.ToJSON()
+		So(json, ShouldContainSubstring, "\"concurrencyLimit\" : \"1\"")
+		So(json, ShouldContainSubstring, "\"inFlight\" : \"1\"")
+		So(json, ShouldContainSubstring, "\"rejected\" : \"1\"")
+
+		close(blocking.release)
+		So(<-valueChan, ShouldEqual, "done")
+	})
+}
@@ -0,0 +1,332 @@
+package goHystrix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandsConfig maps command patterns to CommandOptions.
+// A pattern is "group/name" for a single command or "group/*" for every command in a group,
+// the exact pattern wins over the group pattern.
+//
+// JSON config file:
+//
+//	{
+//	  "payments/*":         { "timeout": "500ms", "errorsThreshold": 25 },
+//	  "payments/authorize": { "timeout": "2s" }
+//	}
+//
+// INI config file:
+//
+//	[payments/*]
+//	timeout = 500ms
+//	errorsThreshold = 25
+//
+// Keys not present in an entry take the values of CommandOptionsDefaults().
+type CommandsConfig struct {
+	options map[string]CommandOptions
+	mutex   sync.RWMutex
+}
+
+// ConfigError is a validation error of a config file, with the line where it happens
+type ConfigError struct {
+	Line    int
+	Pattern string
+	Err     error
+}
+
+var (
+	commandsConfig = NewCommandsConfig()
+	configMutex    sync.RWMutex
+)
+
+func NewCommandsConfig() *CommandsConfig {
+	return &CommandsConfig{options: make(map[string]CommandOptions)}
+}
+
+// Config returns the config used by NewCommand when no options are passed
+func Config() *CommandsConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return commandsConfig
+}
+
+func SetConfig(config *CommandsConfig) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	commandsConfig = config
+}
+
+// CommandOptionsFor returns the options configured for the command, or CommandOptionsDefaults()
+// if there is no pattern that matches
+func CommandOptionsFor(group string, name string) CommandOptions {
+	options, ok := Config().Get(group, name)
+	if !ok {
+		return CommandOptionsDefaults()
+	}
+	return options
+}
+
+// LoadConfigFile reads the config file and sets it as the current config.
+// Files with .json extension are parsed as JSON, any other as INI.
+func LoadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config *CommandsConfig
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		config, err = ParseConfigJSON(bytes.NewReader(data))
+	} else {
+		config, err = ParseConfigINI(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	SetConfig(config)
+	return nil
+}
+
+func ParseConfigJSON(r io.Reader) (*CommandsConfig, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]map[string]json.RawMessage
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			return nil, ConfigError{Line: lineAt(data, e.Offset), Err: err}
+		case *json.UnmarshalTypeError:
+			return nil, ConfigError{Line: lineAt(data, e.Offset), Err: err}
+		}
+		return nil, err
+	}
+
+	// the data is valid, walk the tokens for the line of every key
+	config := NewCommandsConfig()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.Token()
+	for decoder.More() {
+		token, _ := decoder.Token()
+		pattern := token.(string)
+		patternLine := lineAt(data, decoder.InputOffset())
+		options := CommandOptionsDefaults()
+
+		// null is an entry with the defaults
+		if token, _ = decoder.Token(); token == json.Delim('{') {
+			for decoder.More() {
+				token, _ = decoder.Token()
+				key := token.(string)
+				line := lineAt(data, decoder.InputOffset())
+				var raw json.RawMessage
+				decoder.Decode(&raw)
+
+				value := string(raw)
+				if unquoted, err := strconv.Unquote(value); err == nil {
+					value = unquoted
+				}
+				err = setOption(&options, key, value)
+				if err != nil {
+					return nil, ConfigError{Line: line, Pattern: pattern, Err: err}
+				}
+			}
+			decoder.Token()
+		}
+
+		err = config.Set(pattern, options)
+		if err != nil {
+			return nil, ConfigError{Line: patternLine, Pattern: pattern, Err: err}
+		}
+	}
+	return config, nil
+}
+
+func ParseConfigINI(r io.Reader) (*CommandsConfig, error) {
+	config := NewCommandsConfig()
+	scanner := bufio.NewScanner(r)
+
+	pattern := ""
+	patternLine := 0
+	var options CommandOptions
+	line := 0
+
+	flush := func() error {
+		if pattern == "" {
+			return nil
+		}
+		err := config.Set(pattern, options)
+		if err != nil {
+			return ConfigError{Line: patternLine, Pattern: pattern, Err: err}
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, ConfigError{Line: line, Err: fmt.Errorf("unterminated section %q", text)}
+			}
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			pattern = strings.Trim(strings.TrimSpace(text[1:len(text)-1]), "\"")
+			patternLine = line
+			options = CommandOptionsDefaults()
+			continue
+		}
+
+		if pattern == "" {
+			return nil, ConfigError{Line: line, Err: fmt.Errorf("key outside of a [group/name] section")}
+		}
+
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			return nil, ConfigError{Line: line, Pattern: pattern, Err: fmt.Errorf("expected key = value, got %q", text)}
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		err := setOption(&options, key, value)
+		if err != nil {
+			return nil, ConfigError{Line: line, Pattern: pattern, Err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Set stores the options for the pattern, "group/name" or "group/*", if the options are valid
+func (c *CommandsConfig) Set(pattern string, options CommandOptions) error {
+	parts := strings.Split(pattern, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || parts[0] == "*" {
+		return fmt.Errorf("invalid pattern %q, expected group/name or group/*", pattern)
+	}
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.options[pattern] = options
+	return nil
+}
+
+// Get returns the options for the command, looking first for "group/name" and then for "group/*"
+func (c *CommandsConfig) Get(group string, name string) (CommandOptions, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	options, ok := c.options[group+"/"+name]
+	if ok {
+		return options, ok
+	}
+	options, ok = c.options[group+"/*"]
+	return options, ok
+}
+
+func setOption(options *CommandOptions, key string, value string) error {
+	var err error
+	switch key {
+	case "errorsThreshold":
+		options.ErrorsThreshold, err = strconv.ParseFloat(value, 64)
+	case "minimumNumberOfRequest":
+		options.MinimumNumberOfRequest, err = strconv.ParseInt(value, 10, 64)
+	case "numberOfSecondsToStore":
+		options.NumberOfSecondsToStore, err = strconv.Atoi(value)
+	case "numberOfSamplesToStore":
+		options.NumberOfSamplesToStore, err = strconv.Atoi(value)
+	case "timeout":
+		options.Timeout, err = time.ParseDuration(value)
+	case "maxConcurrentRequests":
+		options.MaxConcurrentRequests, err = strconv.Atoi(value)
+	case "hedgeDelay":
+		options.Hedge.Delay, err = time.ParseDuration(value)
+	case "hedgePercentile":
+		options.Hedge.Percentile, err = strconv.ParseFloat(value, 64)
+	case "maxHedges":
+		options.Hedge.MaxHedges, err = strconv.Atoi(value)
+	case "rateLimit":
+		options.RateLimit.Rate, err = strconv.ParseFloat(value, 64)
+	case "rateLimitBurst":
+		options.RateLimit.Burst, err = strconv.Atoi(value)
+	case "rateLimitMaxWait":
+		options.RateLimit.MaxWait, err = time.ParseDuration(value)
+	case "adaptiveConcurrencyInitialLimit":
+		options.AdaptiveConcurrency.InitialLimit, err = strconv.Atoi(value)
+	case "adaptiveConcurrencyMinLimit":
+		options.AdaptiveConcurrency.MinLimit, err = strconv.Atoi(value)
+	case "adaptiveConcurrencyMaxLimit":
+		options.AdaptiveConcurrency.MaxLimit, err = strconv.Atoi(value)
+	case "adaptiveConcurrencyTolerance":
+		options.AdaptiveConcurrency.Tolerance, err = strconv.ParseFloat(value, 64)
+	case "adaptiveConcurrencyBackoff":
+		options.AdaptiveConcurrency.Backoff, err = strconv.ParseFloat(value, 64)
+	case "adaptiveTimeoutPercentile":
+		options.AdaptiveTimeout.Percentile, err = strconv.ParseFloat(value, 64)
+	case "adaptiveTimeoutMultiplier":
+		options.AdaptiveTimeout.Multiplier, err = strconv.ParseFloat(value, 64)
+	case "adaptiveTimeoutMin":
+		options.AdaptiveTimeout.Min, err = time.ParseDuration(value)
+	case "adaptiveTimeoutMax":
+		options.AdaptiveTimeout.Max, err = time.ParseDuration(value)
+	case "latencySample":
+		options.LatencySample = LatencySampleType(value)
+	case "hdrLowest":
+		options.HDR.Lowest, err = time.ParseDuration(value)
+	case "hdrHighest":
+		options.HDR.Highest, err = time.ParseDuration(value)
+	case "hdrSignificantFigures":
+		options.HDR.SignificantFigures, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", key, err.Error())
+	}
+	return nil
+}
+
+// lineAt returns the line number (starting in 1) of the offset in data
+func lineAt(data []byte, offset int64) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func (e ConfigError) Error() string {
+	if e.Pattern == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+	}
+	return fmt.Sprintf("line %d: [%s] %s", e.Line, e.Pattern, e.Err.Error())
+}
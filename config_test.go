@@ -0,0 +1,151 @@
+package goHystrix
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const jsonConfigForTest = `{
+  "payments/*": {
+    "timeout": "500ms",
+    "errorsThreshold": 25
+  },
+  "payments/authorize": {
+    "timeout": "2s",
+    "minimumNumberOfRequest": 5
+  }
+}`
+
+const iniConfigForTest = `
+# payments dependencies
+[payments/*]
+timeout = 500ms
+errorsThreshold = 25
+
+[payments/authorize]
+timeout = "2s"
+minimumNumberOfRequest = 5
+`
+
+func TestConfigLookup(t *testing.T) {
+	Convey("Config file maps patterns to command options", t, func() {
+		for format, parse := range map[string]func() (*CommandsConfig, error){
+			"JSON": func() (*CommandsConfig, error) { return ParseConfigJSON(strings.NewReader(jsonConfigForTest)) },
+			"INI":  func() (*CommandsConfig, error) { return ParseConfigINI(strings.NewReader(iniConfigForTest)) },
+		} {
+			config, err := parse()
+			So(err, ShouldBeNil)
+
+			Convey(format+" exact match wins over the group pattern", func() {
+				options, ok := config.Get("payments", "authorize")
+				So(ok, ShouldBeTrue)
+				So(options.Timeout, ShouldEqual, 2*time.Second)
+				So(options.MinimumNumberOfRequest, ShouldEqual, 5)
+				So(options.ErrorsThreshold, ShouldEqual, CommandOptionsDefaults().ErrorsThreshold)
+			})
+
+			Convey(format+" group pattern matches the rest of the group", func() {
+				options, ok := config.Get("payments", "refund")
+				So(ok, ShouldBeTrue)
+				So(options.Timeout, ShouldEqual, 500*time.Millisecond)
+				So(options.ErrorsThreshold, ShouldEqual, 25.0)
+				So(options.NumberOfSecondsToStore, ShouldEqual, CommandOptionsDefaults().NumberOfSecondsToStore)
+			})
+
+			Convey(format+" other groups are not configured", func() {
+				_, ok := config.Get("users", "authorize")
+				So(ok, ShouldBeFalse)
+			})
+		}
+	})
+}
+
+func TestConfigErrors(t *testing.T) {
+	Convey("Config errors report the line", t, func() {
+		Convey("JSON syntax error", func() {
+			_, err := ParseConfigJSON(strings.NewReader("{\n\"payments/*\": {\n\"timeout\": \"1s\",,\n}\n}"))
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError).Line, ShouldEqual, 3)
+		})
+
+		Convey("JSON unknown key", func() {
+			_, err := ParseConfigJSON(strings.NewReader("{\n\n\"payments/*\": {\"timout\": \"1s\"}\n}"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `line 3: [payments/*] unknown key "timout"`)
+		})
+
+		Convey("JSON invalid value reports the line of its key", func() {
+			data := "{\n\"users/*\": {\"timeout\": \"1s\"},\n\"payments/*\": {\n\"timeout\": \"1s\",\n\"errorsThreshold\": \"lots\"\n}\n}"
+			_, err := ParseConfigJSON(strings.NewReader(data))
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError).Line, ShouldEqual, 5)
+			So(err.Error(), ShouldStartWith, "line 5: [payments/*] invalid value for errorsThreshold")
+		})
+
+		Convey("JSON invalid pattern", func() {
+			_, err := ParseConfigJSON(strings.NewReader("{\n\"payments\": {\"timeout\": \"1s\"}\n}"))
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError).Line, ShouldEqual, 2)
+		})
+
+		Convey("INI invalid value", func() {
+			_, err := ParseConfigINI(strings.NewReader("[payments/*]\ntimeout = 1s\nerrorsThreshold = lots\n"))
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError).Line, ShouldEqual, 3)
+			So(err.Error(), ShouldStartWith, "line 3: [payments/*] invalid value for errorsThreshold")
+		})
+
+		Convey("INI invalid pattern", func() {
+			_, err := ParseConfigINI(strings.NewReader("\n[payments]\ntimeout = 1s\n"))
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError).Line, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestNewCommandUsesConfig(t *testing.T) {
+	Convey("NewCommand takes the options from the loaded config file", t, func() {
+		CircuitsReset()
+		dir, err := ioutil.TempDir("", "goHystrix")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "commands.json")
+		So(ioutil.WriteFile(path, []byte(jsonConfigForTest), 0644), ShouldBeNil)
+		So(LoadConfigFile(path), ShouldBeNil)
+		defer SetConfig(NewCommandsConfig())
+
+		command := NewCommand("authorize", "payments", &ResultCommand{"result", nil, false})
+		So(command.Timeout(), ShouldEqual, 2*time.Second)
+		So(command.circuit.minRequestThreshold, ShouldEqual, 5)
+
+		command = NewCommand("other", "users", &ResultCommand{"result", nil, false})
+		So(command.Timeout(), ShouldEqual, CommandOptionsDefaults().Timeout)
+	})
+}
+
+func TestConfigValidation(t *testing.T) {
+	Convey("Config entries with invalid options are rejected", t, func() {
+		_, err := ParseConfigINI(strings.NewReader("[payments/*]\ntimeout = -1s\n"))
+		So(err, ShouldNotBeNil)
+		So(err.(ConfigError).Line, ShouldEqual, 1)
+	})
+
+	Convey("Config entries select the latency sample", t, func() {
+		config, err := ParseConfigINI(strings.NewReader("[payments/*]\nlatencySample = hdr\nhdrHighest = 10s\nhdrSignificantFigures = 3\n"))
+		So(err, ShouldBeNil)
+		options, ok := config.Get("payments", "authorize")
+		So(ok, ShouldBeTrue)
+		So(options.LatencySample, ShouldEqual, SampleHDR)
+		So(options.HDR.Highest, ShouldEqual, 10*time.Second)
+		So(options.HDR.SignificantFigures, ShouldEqual, 3)
+
+		_, err = ParseConfigINI(strings.NewReader("[payments/*]\nlatencySample = median\n"))
+		So(err, ShouldNotBeNil)
+	})
+}
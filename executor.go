@@ -1,9 +1,12 @@
 package goHystrix
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"github.com/dahernan/goHystrix/sample"
+	"log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,6 +19,13 @@ type FallbackInterface interface {
 	Fallback() (interface{}, error)
 }
 
+// HedgeInterface is a command that can be hedged, NewAttempt returns the command for every
+// hedged attempt, a new one, or the same one if its Run is safe to call concurrently
+type HedgeInterface interface {
+	Interface
+	NewAttempt() Interface
+}
+
 type Command struct {
 	Interface
 	*Executor
@@ -24,7 +34,7 @@ type Command struct {
 type Executor struct {
 	group   string
 	name    string
-	timeout time.Duration
+	hedge   HedgeOptions
 	command Interface
 	circuit *CircuitBreaker
 }
@@ -40,14 +50,112 @@ type CommandError struct {
 // ErrorsThreshold - if number_of_errors / total_calls * 100 > errorThreshold the circuit will be open
 // MinimumNumberOfRequest - if total_calls < minimumNumberOfRequest the circuit will be close
 // NumberOfSecondsToStore - Is the number of seconds to count the stats, for example 10 stores just the last 10 seconds of calls
-// NumberOfSamplesToStore - Is the number of samples to store for calculate the stats, greater means more precision to get Mean, Max, Min...
+// NumberOfSamplesToStore - Is the number of samples to store for calculate the stats with SampleExpDecay, greater means more precision to get Mean, Max, Min...
 // Timeout - the timeout for the command
+// MaxConcurrentRequests - the number of calls that can be in flight at the same time, the rest go to the fallback (0 is unlimited)
+// Hedge - launches more attempts of slow calls, disabled by default
+// AdaptiveTimeout - the timeout follows the latency of the command, disabled by default
+// RateLimit - limits the calls per second, disabled by default
+// AdaptiveConcurrency - a concurrency limit that follows the latency, it replaces MaxConcurrentRequests, disabled by default
+// LatencySample - where the latencies are recorded, SampleRolling by default
+// HDR - the range and the precision of the SampleRolling and SampleHDR histograms
 type CommandOptions struct {
 	ErrorsThreshold        float64
 	MinimumNumberOfRequest int64
 	NumberOfSecondsToStore int
 	NumberOfSamplesToStore int
 	Timeout                time.Duration
+	MaxConcurrentRequests  int
+	Hedge                  HedgeOptions
+	AdaptiveTimeout        AdaptiveTimeoutOptions
+	RateLimit              RateLimitOptions
+	AdaptiveConcurrency    AdaptiveConcurrencyOptions
+	LatencySample          LatencySampleType
+	HDR                    HDROptions
+}
+
+// LatencySampleType selects the sample.Sample of the latencies, for the percentiles of
+// ToJSON, the exporters, the hedging and the adaptive timeout
+type LatencySampleType string
+
+const (
+	// SampleRolling records the latencies of the last NumberOfSecondsToStore in HDR histograms,
+	// one per second like the health counts
+	SampleRolling LatencySampleType = "rolling"
+	// SampleExpDecay is a reservoir of NumberOfSamplesToStore values, biased to the recent ones
+	SampleExpDecay LatencySampleType = "expdecay"
+	// SampleHDR records all the latencies since the start in a HDR histogram
+	SampleHDR LatencySampleType = "hdr"
+)
+
+// HDROptions, the latencies between Lowest and Highest are recorded with SignificantFigures of precision,
+// the ones out of the range are recorded as Lowest or Highest
+// Lowest - 1 microsecond if 0
+// Highest - 1 minute if 0
+// SignificantFigures - 1 to 5, 2 (1% of error) if 0
+type HDROptions struct {
+	Lowest             time.Duration
+	Highest            time.Duration
+	SignificantFigures int
+}
+
+// HedgeOptions, for idempotent commands, if the call has not finished after the delay
+// another attempt is launched and the first answer wins.
+// The command must implement HedgeInterface, the attempts run concurrently and share the circuit and the MaxConcurrentRequests.
+// Delay - fixed delay before a new attempt
+// Percentile - the delay is the percentile of the latency, for example 0.95, Delay is used until there are samples
+// MaxHedges - maximum number of extra attempts, 0 disables the hedging
+type HedgeOptions struct {
+	Delay      time.Duration
+	Percentile float64
+	MaxHedges  int
+}
+
+// AdaptiveTimeoutOptions, the timeout is the percentile of the latency * Multiplier, clamped between Min and Max.
+// Timeout is used until there are samples of the latency.
+// Percentile - for example 0.99, 0 disables the adaptive timeout
+// Multiplier - 1 if 0
+// Min - minimum timeout
+// Max - maximum timeout, Timeout if 0
+type AdaptiveTimeoutOptions struct {
+	Percentile float64
+	Multiplier float64
+	Min        time.Duration
+	Max        time.Duration
+}
+
+// RateLimitOptions, token bucket rate limit checked before the circuit,
+// the throttled calls go to the fallback and are counted in HealthCounts().Throttled
+// Rate - calls per second, 0 disables the rate limit
+// Burst - maximum number of calls at once, 1 if 0
+// MaxWait - a throttled call waits up to MaxWait for its turn before going to the fallback
+type RateLimitOptions struct {
+	Rate    float64
+	Burst   int
+	MaxWait time.Duration
+}
+
+// AdaptiveConcurrencyOptions, AIMD limit of the calls in flight: it grows by one while the latency stays under
+// the minimum latency * Tolerance and the calls use at least half of the limit, and it is multiplied by Backoff
+// when the latency rises over it or a call times out. The rejected calls go to the fallback.
+// InitialLimit - the first limit, 0 disables the adaptive limit
+// MinLimit - 1 if 0
+// MaxLimit - 1000 if 0
+// Tolerance - 2 if 0
+// Backoff - 0.9 if 0
+type AdaptiveConcurrencyOptions struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	Tolerance    float64
+	Backoff      float64
+}
+
+// result of an attempt of the command
+type result struct {
+	value   interface{}
+	err     error
+	elapsed time.Duration
 }
 
 // CommandOptionsDefaults
@@ -56,6 +164,7 @@ type CommandOptions struct {
 // NumberOfSecondsToStore - 20 seconds
 // NumberOfSamplesToStore - 50 values
 // Timeout - 2 * time.Seconds
+// MaxConcurrentRequests - 0, unlimited
 func CommandOptionsDefaults() CommandOptions {
 	return CommandOptions{
 		ErrorsThreshold:        50.0,
@@ -67,82 +176,284 @@ func CommandOptionsDefaults() CommandOptions {
 
 }
 
-// NewCommand- create a new command with the default values
+// Validate checks that the options make sense, the zero values are not valid
+// (NewCommandWithOptions replaces them with the defaults before validating)
+func (options CommandOptions) Validate() error {
+	if options.ErrorsThreshold <= 0 || options.ErrorsThreshold > 100 {
+		return fmt.Errorf("invalid CommandOptions: ErrorsThreshold must be in (0, 100], got %v", options.ErrorsThreshold)
+	}
+	if options.MinimumNumberOfRequest <= 0 {
+		return fmt.Errorf("invalid CommandOptions: MinimumNumberOfRequest must be greater than 0, got %d", options.MinimumNumberOfRequest)
+	}
+	if options.NumberOfSecondsToStore <= 0 {
+		return fmt.Errorf("invalid CommandOptions: NumberOfSecondsToStore must be greater than 0, got %d", options.NumberOfSecondsToStore)
+	}
+	if options.NumberOfSamplesToStore <= 0 {
+		return fmt.Errorf("invalid CommandOptions: NumberOfSamplesToStore must be greater than 0, got %d", options.NumberOfSamplesToStore)
+	}
+	if options.Timeout <= 0 {
+		return fmt.Errorf("invalid CommandOptions: Timeout must be greater than 0, got %s", options.Timeout)
+	}
+	if options.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("invalid CommandOptions: MaxConcurrentRequests must be 0 or greater, got %d", options.MaxConcurrentRequests)
+	}
+	if options.Hedge.MaxHedges < 0 || options.Hedge.Delay < 0 || options.Hedge.Percentile < 0 || options.Hedge.Percentile >= 1 {
+		return fmt.Errorf("invalid CommandOptions: Hedge must have MaxHedges >= 0, Delay >= 0 and Percentile in [0, 1), got %+v", options.Hedge)
+	}
+	if options.Hedge.MaxHedges > 0 && options.Hedge.Delay == 0 && options.Hedge.Percentile == 0 {
+		return fmt.Errorf("invalid CommandOptions: Hedge needs a Delay or a Percentile")
+	}
+	adaptive := options.AdaptiveTimeout
+	if adaptive.Percentile < 0 || adaptive.Percentile >= 1 || adaptive.Multiplier < 0 || adaptive.Min < 0 || adaptive.Max < 0 {
+		return fmt.Errorf("invalid CommandOptions: AdaptiveTimeout must have Percentile in [0, 1) and Multiplier, Min and Max >= 0, got %+v", adaptive)
+	}
+	if adaptive.Max > 0 && adaptive.Min > adaptive.Max {
+		return fmt.Errorf("invalid CommandOptions: AdaptiveTimeout Min (%s) is greater than Max (%s)", adaptive.Min, adaptive.Max)
+	}
+	if options.RateLimit.Rate < 0 || options.RateLimit.Burst < 0 || options.RateLimit.MaxWait < 0 {
+		return fmt.Errorf("invalid CommandOptions: RateLimit must have Rate, Burst and MaxWait >= 0, got %+v", options.RateLimit)
+	}
+	concurrency := options.AdaptiveConcurrency.mergeDefaults()
+	if options.AdaptiveConcurrency.InitialLimit < 0 || concurrency.MinLimit < 0 || concurrency.MaxLimit < concurrency.MinLimit ||
+		concurrency.Tolerance < 1 || concurrency.Backoff <= 0 || concurrency.Backoff >= 1 {
+		return fmt.Errorf("invalid CommandOptions: AdaptiveConcurrency must have 0 <= MinLimit <= MaxLimit, Tolerance >= 1 and Backoff in (0, 1), got %+v", options.AdaptiveConcurrency)
+	}
+	switch options.LatencySample {
+	case "", SampleRolling, SampleExpDecay, SampleHDR:
+	default:
+		return fmt.Errorf("invalid CommandOptions: LatencySample must be %q, %q or %q, got %q", SampleRolling, SampleExpDecay, SampleHDR, options.LatencySample)
+	}
+	hdr := options.HDR.mergeDefaults()
+	if options.HDR.Lowest < 0 || hdr.Highest <= hdr.Lowest || hdr.SignificantFigures < 1 || hdr.SignificantFigures > 5 {
+		return fmt.Errorf("invalid CommandOptions: HDR must have 0 <= Lowest < Highest and SignificantFigures in [1, 5], got %+v", options.HDR)
+	}
+	return nil
+}
+
+// WithDefaults returns the options with the zero values replaced by CommandOptionsDefaults(),
+// options.WithDefaults().Validate() is the check of NewCommandWithOptions
+func (options CommandOptions) WithDefaults() CommandOptions {
+	return options.mergeDefaults()
+}
+
+// mergeDefaults returns the options with the zero values replaced by CommandOptionsDefaults()
+func (options CommandOptions) mergeDefaults() CommandOptions {
+	defaults := CommandOptionsDefaults()
+	if options.ErrorsThreshold == 0 {
+		options.ErrorsThreshold = defaults.ErrorsThreshold
+	}
+	if options.MinimumNumberOfRequest == 0 {
+		options.MinimumNumberOfRequest = defaults.MinimumNumberOfRequest
+	}
+	if options.NumberOfSecondsToStore == 0 {
+		options.NumberOfSecondsToStore = defaults.NumberOfSecondsToStore
+	}
+	if options.NumberOfSamplesToStore == 0 {
+		options.NumberOfSamplesToStore = defaults.NumberOfSamplesToStore
+	}
+	if options.Timeout == 0 {
+		options.Timeout = defaults.Timeout
+	}
+	if options.LatencySample == "" {
+		options.LatencySample = SampleRolling
+	}
+	return options
+}
+
+func (h HDROptions) mergeDefaults() HDROptions {
+	if h.Lowest == 0 {
+		h.Lowest = time.Microsecond
+	}
+	if h.Highest == 0 {
+		h.Highest = time.Minute
+	}
+	if h.SignificantFigures == 0 {
+		h.SignificantFigures = 2
+	}
+	return h
+}
+
+// latencySample returns the sample of the latencies selected by the options
+func (options CommandOptions) latencySample() sample.Sample {
+	hdr := options.HDR.mergeDefaults()
+	switch options.LatencySample {
+	case SampleExpDecay:
+		return sample.NewExpDecaySample(options.NumberOfSamplesToStore, alpha)
+	case SampleHDR:
+		return sample.NewHDRHistogram(int64(hdr.Lowest), int64(hdr.Highest), hdr.SignificantFigures)
+	}
+	return sample.NewRollingHistogram(options.NumberOfSecondsToStore, time.Second, int64(hdr.Lowest), int64(hdr.Highest), hdr.SignificantFigures)
+}
+
+// NewCommand- create a new command with the options from Config(), or the default values
 func NewCommand(name string, group string, command Interface) *Command {
-	executor := NewExecutor(name, group, command, CommandOptionsDefaults())
-	return &Command{Interface: command, Executor: executor}
+	return MustNewCommand(name, group, command, CommandOptionsFor(group, name))
+}
+
+// NewCommandWithOptions - create a new command, the options not set take the default values
+// and it returns an error if the options are not valid
+func NewCommandWithOptions(name string, group string, command Interface, options CommandOptions) (*Command, error) {
+	executor, err := NewExecutor(name, group, command, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Command{Interface: command, Executor: executor}, nil
 }
 
-func NewCommandWithOptions(name string, group string, command Interface, options CommandOptions) *Command {
-	executor := NewExecutor(name, group, command, options)
-	return &Command{Interface: command, Executor: executor}
+// MustNewCommand - like NewCommandWithOptions but it panics if the options are not valid
+func MustNewCommand(name string, group string, command Interface, options CommandOptions) *Command {
+	c, err := NewCommandWithOptions(name, group, command, options)
+	if err != nil {
+		panic(err)
+	}
+	return c
 }
 
-func NewExecutor(name string, group string, command Interface, options CommandOptions) *Executor {
-	circuit := NewCircuit(group, name, options)
+func NewExecutor(name string, group string, command Interface, options CommandOptions) (*Executor, error) {
+	options = options.mergeDefaults()
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := command.(HedgeInterface); options.Hedge.MaxHedges > 0 && !ok {
+		return nil, fmt.Errorf("invalid CommandOptions: Hedge needs a command that implements HedgeInterface, %s:%s doesn't", group, name)
+	}
+
+	circuit, err := NewCircuitWithOptions(group, name, options)
+	if err != nil {
+		return nil, err
+	}
 	return &Executor{
 		group:   group,
 		name:    name,
-		timeout: options.Timeout,
+		hedge:   options.Hedge,
 		command: command,
 		circuit: circuit,
+	}, nil
+}
+
+// doExecute runs the command, release is called when the first attempt returns,
+// so the run holds its slot even after a timeout
+func (ex *Executor) doExecute(ctx context.Context, release func(timedOut bool)) (value interface{}, err error) {
+	_, span := ex.startSpan(ctx, "goHystrix.run")
+	hedges := 0
+	defer func() {
+		span.SetAttribute("attempts", 1+hedges)
+		span.SetAttribute("outcome", outcome(err))
+		span.End()
+	}()
+
+	// buffered for all the attempts, the ones that lose don't block
+	resultChan := make(chan result, 1+ex.hedge.MaxHedges)
+	var timedOut int32
+	ex.attempt(ex.command, resultChan, func() { release(atomic.LoadInt32(&timedOut) == 1) })
+
+	timeout := ex.Timeout()
+	timeoutChan := time.After(timeout)
+	var hedgeTimer <-chan time.Time
+	if ex.hedge.MaxHedges > 0 {
+		hedgeTimer = ex.hedgeTimer()
 	}
+
+	for {
+		select {
+		case r := <-resultChan:
+			if r.err != nil {
+				ex.Metric().FailWithError(r.err)
+				return nil, r.err
+			}
+			ex.Metric().Success(r.elapsed)
+			return r.value, nil
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if !ex.circuit.Acquire() {
+				continue
+			}
+			hedges++
+			ex.Metric().Hedge()
+			span.AddEvent("hedge", map[string]interface{}{"attempt": 1 + hedges})
+			// the hedged attempt holds its slot until it finishes, even if another attempt wins
+			ex.attempt(ex.command.(HedgeInterface).NewAttempt(), resultChan, ex.circuit.Release)
+			if hedges < ex.hedge.MaxHedges {
+				hedgeTimer = ex.hedgeTimer()
+			}
+		case <-timeoutChan:
+			atomic.StoreInt32(&timedOut, 1)
+			err = timeoutError{group: ex.group, name: ex.name, timeout: timeout}
+			ex.Metric().TimeoutWithError(err)
+			return nil, err
+		}
+	}
+
 }
 
-func (ex *Executor) doExecute() (interface{}, error) {
-	valueChan := make(chan interface{}, 1)
-	errorChan := make(chan error, 1)
-	var elapsed time.Duration
+// attempt runs the command in a goroutine and sends the result to the channel,
+// release is called when the run returns, before the result is sent, if it is not nil
+func (ex *Executor) attempt(command Interface, resultChan chan<- result, release func()) {
 	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				ex.Metric().Panic()
-				errorChan <- fmt.Errorf("Recovered from panic: %v", r)
-			}
-		}()
-		start := time.Now()
-		value, err := ex.command.Run()
-		elapsed = time.Since(start)
-		if err != nil {
-			errorChan <- err
-		} else {
-			valueChan <- value
+		r := ex.run(command)
+		if release != nil {
+			release()
 		}
+		resultChan <- r
 	}()
+}
 
-	select {
-	case value := <-valueChan:
-		ex.Metric().Success(elapsed)
-		return value, nil
-	case err := <-errorChan:
-		ex.Metric().Fail()
-		return nil, err
-	case <-time.After(ex.timeout):
-		ex.Metric().Timeout()
-		return nil, fmt.Errorf("error: Timeout (%s), executing command %s:%s", ex.timeout, ex.group, ex.name)
-	}
+// run runs the command and recovers the panics
+func (ex *Executor) run(command Interface) (r result) {
+	defer func() {
+		if p := recover(); p != nil {
+			ex.Metric().Panic()
+			r = result{err: fmt.Errorf("Recovered from panic: %v", p)}
+		}
+	}()
+	start := time.Now()
+	value, err := command.Run()
+	return result{value: value, err: err, elapsed: time.Since(start)}
+}
 
+// hedgeTimer fires after the hedge delay, the percentile of the latency or the fixed Delay,
+// it returns nil (never fires) if there is no delay yet
+func (ex *Executor) hedgeTimer() <-chan time.Time {
+	delay := ex.hedge.Delay
+	if ex.hedge.Percentile > 0 {
+		if p := time.Duration(ex.Metric().Stats().Percentile(ex.hedge.Percentile)); p > 0 {
+			delay = p
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	return time.After(delay)
 }
 
-func (ex *Executor) doFallback(nestedError error) (interface{}, error) {
+func (ex *Executor) doFallback(ctx context.Context, nestedError error) (interface{}, error) {
 	ex.Metric().Fallback()
+	_, span := ex.startSpan(ctx, "goHystrix.fallback")
+	defer span.End()
+	if nestedError != nil {
+		span.SetAttribute("error", nestedError.Error())
+	}
 
 	fbCmd, ok := ex.command.(FallbackInterface)
 	if !ok {
 		ex.Metric().FallbackError()
+		span.SetAttribute("outcome", "missing")
 		return nil, NewCommandError(ex.group, ex.name, nestedError, fmt.Errorf("No fallback implementation available for %s", ex.name))
 	}
 
 	value, err := fbCmd.Fallback()
 	if err != nil {
 		ex.Metric().FallbackError()
+		span.SetAttribute("outcome", "failure")
 		return value, NewCommandError(ex.group, ex.name, nestedError, err)
 	}
+	span.SetAttribute("outcome", "success")
 
-	// log the nested error
-	if nestedError != nil {
+	// log the nested error, the open circuit is not an error of the command
+	if nestedError != nil && nestedError != ErrCircuitOpen {
 		commandError := NewCommandError(ex.group, ex.name, nestedError, nil)
-		log.Println(commandError.Error())
+		logEvent(slog.LevelWarn, "command.error", commandError.Error(), "group", ex.group, "name", ex.name, "error", nestedError)
 	}
 
 	return value, err
@@ -150,19 +461,65 @@ func (ex *Executor) doFallback(nestedError error) (interface{}, error) {
 }
 
 func (ex *Executor) Execute() (interface{}, error) {
-	open, _ := ex.circuit.IsOpen()
+	return ex.ExecuteContext(context.Background())
+}
+
+// ExecuteContext is Execute with the context as the parent of the spans of the Tracer,
+// the context doesn't cancel the command
+func (ex *Executor) ExecuteContext(ctx context.Context) (interface{}, error) {
+	ctx, span := ex.startSpan(ctx, "goHystrix.execute")
+	defer span.End()
+
+	if !ex.circuit.Allow() {
+		ex.Metric().Throttled()
+		span.SetAttribute("outcome", "throttled")
+		return ex.doFallback(ctx, ErrThrottled)
+	}
+
+	open, state := ex.circuit.IsOpen()
+	span.SetAttribute("circuit.open", open)
+	span.SetAttribute("circuit.state", state)
 	if open {
-		return ex.doFallback(nil)
+		span.SetAttribute("outcome", "short_circuited")
+		return ex.doFallback(ctx, ErrCircuitOpen)
 	}
 
-	value, err := ex.doExecute()
+	if !ex.circuit.Acquire() {
+		ex.Metric().RejectedWithError(ErrMaxConcurrency)
+		span.SetAttribute("outcome", "rejected")
+		return ex.doFallback(ctx, ErrMaxConcurrency)
+	}
+	start := time.Now()
+	value, err := ex.doExecute(ctx, func(timedOut bool) {
+		ex.circuit.ReleaseWithLatency(time.Since(start), timedOut)
+	})
+	span.SetAttribute("outcome", outcome(err))
 	if err != nil {
-		return ex.doFallback(err)
+		return ex.doFallback(ctx, err)
 	}
 	return value, err
 
 }
 
+// startSpan starts a span of the Tracer with the group and the name
+func (ex *Executor) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	ctx, span := CurrentTracer().Start(ctx, name)
+	span.SetAttribute("group", ex.group)
+	span.SetAttribute("name", ex.name)
+	return ctx, span
+}
+
+// outcome is the outcome of the run for the spans
+func outcome(err error) string {
+	if err == nil {
+		return "success"
+	}
+	if _, ok := err.(timeoutError); ok {
+		return "timeout"
+	}
+	return "failure"
+}
+
 func (ex *Executor) Queue() (chan interface{}, chan error) {
 	valueChan := make(chan interface{}, 1)
 	errorChan := make(chan error, 1)
@@ -179,6 +536,40 @@ func (ex *Executor) Queue() (chan interface{}, chan error) {
 	return valueChan, errorChan
 }
 
+// Timeout returns the effective timeout, the adaptive one if it is set
+func (ex *Executor) Timeout() time.Duration {
+	return ex.circuit.Timeout()
+}
+
+// timeout calculates the adaptive timeout from the stats, or returns the fixed one
+func (a AdaptiveTimeoutOptions) timeout(fixed time.Duration, stats sample.Sample) time.Duration {
+	if a.Percentile <= 0 {
+		return fixed
+	}
+	p := stats.Percentile(a.Percentile)
+	if p <= 0 {
+		return fixed
+	}
+
+	multiplier := a.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	max := a.Max
+	if max == 0 {
+		max = fixed
+	}
+
+	timeout := time.Duration(p * multiplier)
+	if timeout < a.Min {
+		timeout = a.Min
+	}
+	if timeout > max {
+		timeout = max
+	}
+	return timeout
+}
+
 func (ex *Executor) Metric() *Metric {
 	return ex.circuit.Metric()
 }
@@ -187,12 +578,24 @@ func (ex *Executor) HealthCounts() HealthCounts {
 	return ex.Metric().HealthCounts()
 }
 
+// timeoutError is the error of the commands that time out
+type timeoutError struct {
+	group   string
+	name    string
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("error: Timeout (%s), executing command %s:%s", e.timeout, e.group, e.name)
+}
+
 // Nested error handling
 func (e CommandError) Error() string {
 	runErrorText := ""
 	fallbackErrorText := ""
 	commandText := fmt.Sprintf("[%s:%s]", e.group, e.name)
-	if e.runError != nil {
+	if e.runError != nil && e.runError != ErrCircuitOpen {
+		// with the circuit open there is no run, Unwrap returns ErrCircuitOpen
 		runErrorText = fmt.Sprintf("RunError: %s", e.runError.Error())
 
 	}
@@ -203,6 +606,16 @@ func (e CommandError) Error() string {
 	return strings.TrimSpace(fmt.Sprintf("%s %s %s", commandText, fallbackErrorText, runErrorText))
 }
 
+// Unwrap returns the nested error, the error of the run (or ErrCircuitOpen, ErrThrottled, ErrMaxConcurrency
+// when the command didn't run), or the error of the fallback if there is none,
+// so errors.Is(err, goHystrix.ErrCircuitOpen) works with the errors of Execute
+func (e CommandError) Unwrap() error {
+	if e.runError != nil {
+		return e.runError
+	}
+	return e.fallbackError
+}
+
 func NewCommandError(group string, name string, runError error, fallbackError error) CommandError {
 	return CommandError{group, name, runError, fallbackError}
 }
@@ -232,14 +645,12 @@ func (c CommandFuncFallbackWrap) Run() (interface{}, error) {
 
 func NewCommandFunc(name string, group string, commandFunc CommandFunc) *Command {
 	command := CommandFuncWrap{commandFunc}
-	executor := NewExecutor(name, group, command, CommandOptionsDefaults())
-	return &Command{Interface: command, Executor: executor}
+	return MustNewCommand(name, group, command, CommandOptionsFor(group, name))
 }
 func NewCommandFuncFallback(name string, group string, commandFunc CommandFunc, fallbackFunc CommandFunc) *Command {
 	command := CommandFuncFallbackWrap{
 		run:      commandFunc,
 		fallback: fallbackFunc,
 	}
-	executor := NewExecutor(name, group, command, CommandOptionsDefaults())
-	return &Command{Interface: command, Executor: executor}
+	return MustNewCommand(name, group, command, CommandOptionsFor(group, name))
 }
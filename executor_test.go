@@ -1,8 +1,12 @@
 package goHystrix
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/dahernan/goHystrix/sample"
 	. "github.com/smartystreets/goconvey/convey"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -21,6 +25,10 @@ func (rc *ResultCommand) Run() (interface{}, error) {
 	return rc.result, rc.err
 }
 
+func (rc *ResultCommand) NewAttempt() Interface {
+	return rc
+}
+
 func CommandOptionsForTest() CommandOptions {
 	return CommandOptions{
 		ErrorsThreshold:        50.0,
@@ -35,7 +43,7 @@ func CommandOptionsForTest() CommandOptions {
 func TestRunErrors(t *testing.T) {
 	Convey("Command returns basic value, no error", t, func() {
 		CircuitsReset()
-		command := NewCommandWithOptions("ResultCommand", "testGroup", &ResultCommand{"result", nil, false}, CommandOptionsForTest())
+		command := MustNewCommand("ResultCommand", "testGroup", &ResultCommand{"result", nil, false}, CommandOptionsForTest())
 
 		Convey("run", func() {
 			result, err := command.Execute()
@@ -47,7 +55,7 @@ func TestRunErrors(t *testing.T) {
 
 	Convey("Command returns nil, nil", t, func() {
 		CircuitsReset()
-		command := NewCommandWithOptions("ResultCommand", "testGroup", &ResultCommand{nil, nil, false}, CommandOptionsForTest())
+		command := MustNewCommand("ResultCommand", "testGroup", &ResultCommand{nil, nil, false}, CommandOptionsForTest())
 
 		Convey("run", func() {
 			result, err := command.Execute()
@@ -59,7 +67,7 @@ func TestRunErrors(t *testing.T) {
 
 	Convey("Command returns value, error", t, func() {
 		CircuitsReset()
-		command := NewCommandWithOptions("ResultCommand", "testGroup", &ResultCommand{"result", fmt.Errorf("some error"), false}, CommandOptionsForTest())
+		command := MustNewCommand("ResultCommand", "testGroup", &ResultCommand{"result", fmt.Errorf("some error"), false}, CommandOptionsForTest())
 
 		Convey("run", func() {
 			result, err := command.Execute()
@@ -71,7 +79,7 @@ func TestRunErrors(t *testing.T) {
 
 	Convey("Command panics!", t, func() {
 		CircuitsReset()
-		command := NewCommandWithOptions("ResultCommand", "testGroup", &ResultCommand{nil, nil, true}, CommandOptionsForTest())
+		command := MustNewCommand("ResultCommand", "testGroup", &ResultCommand{nil, nil, true}, CommandOptionsForTest())
 
 		Convey("run", func() {
 			result, err := command.Execute()
@@ -94,7 +102,7 @@ func (cmd *NoFallbackCommand) Run() (interface{}, error) {
 func TestRunNoFallback(t *testing.T) {
 	Convey("Command Execute errors directly, without fallback implementation", t, func() {
 		CircuitsReset()
-		errorCommand := NewCommandWithOptions("nofallbackCmd", "testGroup", &NoFallbackCommand{"error"}, CommandOptionsForTest())
+		errorCommand := MustNewCommand("nofallbackCmd", "testGroup", &NoFallbackCommand{"error"}, CommandOptionsForTest())
 
 		Convey("After 3 errors, the circuit is open and the next call is using the fallback", func() {
 			var result interface{}
@@ -136,7 +144,7 @@ func NewStringCommand(state string, fallbackState string) *Command {
 	command.state = state
 	command.fallbackState = fallbackState
 
-	return NewCommandWithOptions("testCommand", "testGroup", command, CommandOptionsForTest())
+	return MustNewCommand("testCommand", "testGroup", command, CommandOptionsForTest())
 }
 
 func (c *StringCommand) Run() (interface{}, error) {
@@ -304,6 +312,25 @@ func TestFallbackError(t *testing.T) {
 	})
 }
 
+func TestCommandErrorUnwrap(t *testing.T) {
+	Convey("The errors of Execute unwrap to the nested error", t, func() {
+		CircuitsReset()
+		errRun := errors.New("run error")
+		command := MustNewCommand("unwrapCmd", "testGroup", &ResultCommand{nil, errRun, false}, CommandOptionsForTest())
+
+		_, err := command.Execute()
+		So(errors.Is(err, errRun), ShouldBeTrue)
+
+		Convey("An open circuit is ErrCircuitOpen", func() {
+			for i := 0; i < 3; i++ {
+				command.Metric().Fail()
+			}
+			_, err := command.Execute()
+			So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+		})
+	})
+}
+
 func TestExecuteTimeout(t *testing.T) {
 
 	Convey("Command returns the fallback due to timeout", t, func() {
@@ -511,3 +538,332 @@ func TestMetrics(t *testing.T) {
 
 	})
 }
+
+func TestCommandOptionsValidate(t *testing.T) {
+	Convey("Command options are validated", t, func() {
+		CircuitsReset()
+
+		Convey("Defaults are valid", func() {
+			So(CommandOptionsDefaults().Validate(), ShouldBeNil)
+		})
+
+		Convey("Zero values are not valid by themselves", func() {
+			So(CommandOptions{}.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("Partial options are merged with the defaults", func() {
+			command, err := NewCommandWithOptions("partialCmd", "testGroup", &ResultCommand{"result", nil, false}, CommandOptions{Timeout: 5 * time.Millisecond})
+			So(err, ShouldBeNil)
+			So(command.Timeout(), ShouldEqual, 5*time.Millisecond)
+			So(command.circuit.errorsThreshold, ShouldEqual, CommandOptionsDefaults().ErrorsThreshold)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "result")
+		})
+
+		Convey("Nonsensical values return an error", func() {
+			for _, options := range []CommandOptions{
+				{Timeout: -1 * time.Second},
+				{ErrorsThreshold: -1},
+				{ErrorsThreshold: 101},
+				{MinimumNumberOfRequest: -1},
+				{NumberOfSecondsToStore: -1},
+				{NumberOfSamplesToStore: -1},
+				{LatencySample: "foo"},
+				{HDR: HDROptions{SignificantFigures: 6}},
+				{HDR: HDROptions{Lowest: time.Second, Highest: time.Millisecond}},
+			} {
+				command, err := NewCommandWithOptions("invalidCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+				So(err, ShouldNotBeNil)
+				So(command, ShouldBeNil)
+			}
+		})
+
+		Convey("MustNewCommand panics with invalid options", func() {
+			So(func() {
+				MustNewCommand("invalidCmd", "testGroup", &ResultCommand{"result", nil, false}, CommandOptions{Timeout: -1})
+			}, ShouldPanic)
+		})
+	})
+}
+
+type BlockingCommand struct {
+	release chan struct{}
+}
+
+func (c *BlockingCommand) Run() (interface{}, error) {
+	<-c.release
+	return "done", nil
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	Convey("Calls over MaxConcurrentRequests are rejected", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = time.Second
+		options.MaxConcurrentRequests = 1
+		blocking := &BlockingCommand{make(chan struct{})}
+		command := MustNewCommand("blockingCmd", "testGroup", blocking, options)
+
+		valueChan, _ := command.Queue()
+		for _, inFlight := command.circuit.ConcurrencyLimit(); inFlight == 0; _, inFlight = command.circuit.ConcurrencyLimit() {
+			time.Sleep(time.Millisecond)
+		}
+
+		_, err := command.Execute()
+		So(errors.Is(err, ErrMaxConcurrency), ShouldBeTrue)
+
+		close(blocking.release)
+		So(<-valueChan, ShouldEqual, "done")
+		So(command.circuit.Acquire(), ShouldBeTrue)
+		command.circuit.Release()
+	})
+
+	Convey("Timed out calls hold the slot until the run returns", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = 10 * time.Millisecond
+		options.MaxConcurrentRequests = 1
+		blocking := &BlockingCommand{make(chan struct{})}
+		command := MustNewCommand("blockingCmd", "testGroup", blocking, options)
+
+		_, err := command.Execute()
+		So(err.Error(), ShouldContainSubstring, "Timeout")
+		_, inFlight := command.circuit.ConcurrencyLimit()
+		So(inFlight, ShouldEqual, 1)
+
+		_, err = command.Execute()
+		So(errors.Is(err, ErrMaxConcurrency), ShouldBeTrue)
+
+		close(blocking.release)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		So(Circuits().waitInFlight(ctx), ShouldBeNil)
+	})
+}
+
+// SlowFirstCommand is slow only in the first call
+type SlowFirstCommand struct {
+	calls int32
+}
+
+func (c *SlowFirstCommand) Run() (interface{}, error) {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		time.Sleep(50 * time.Millisecond)
+		return "slow", nil
+	}
+	return "fast", nil
+}
+
+func (c *SlowFirstCommand) NewAttempt() Interface {
+	return c
+}
+
+// SlowHedgeCommand answers the first call after 20ms and blocks the others until release is closed
+type SlowHedgeCommand struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (c *SlowHedgeCommand) Run() (interface{}, error) {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		time.Sleep(20 * time.Millisecond)
+		return "first", nil
+	}
+	<-c.release
+	return "hedge", nil
+}
+
+func (c *SlowHedgeCommand) NewAttempt() Interface {
+	return c
+}
+
+// AttemptCommand is slow in the first attempt, every hedged attempt is a new command
+type AttemptCommand struct {
+	attempt  int
+	attempts *int32
+}
+
+func (c *AttemptCommand) Run() (interface{}, error) {
+	if c.attempt == 0 {
+		time.Sleep(50 * time.Millisecond)
+		return "slow", nil
+	}
+	return fmt.Sprintf("attempt %d", c.attempt), nil
+}
+
+func (c *AttemptCommand) NewAttempt() Interface {
+	return &AttemptCommand{attempt: int(atomic.AddInt32(c.attempts, 1)), attempts: c.attempts}
+}
+
+func TestHedgedRequests(t *testing.T) {
+	Convey("Slow calls launch a hedged attempt", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = 100 * time.Millisecond
+
+		Convey("With a fixed delay the first answer wins", func() {
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, MaxHedges: 1}
+			cmd := &SlowFirstCommand{}
+			command := MustNewCommand("hedgedCmd", "testGroup", cmd, options)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "fast")
+			So(atomic.LoadInt32(&cmd.calls), ShouldEqual, 2)
+
+			counts := command.HealthCounts()
+			So(counts.Hedges, ShouldEqual, 1)
+			So(counts.Success, ShouldEqual, 1)
+			So(counts.Total, ShouldEqual, 1)
+		})
+
+		Convey("With a percentile and no samples the fixed delay is used", func() {
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, Percentile: 0.95, MaxHedges: 2}
+			cmd := &SlowFirstCommand{}
+			command := MustNewCommand("hedgedPercentileCmd", "testGroup", cmd, options)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "fast")
+			So(command.HealthCounts().Hedges, ShouldEqual, 1)
+		})
+
+		Convey("Hedged attempts hold their slot until they finish", func() {
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, MaxHedges: 1}
+			cmd := &SlowHedgeCommand{release: make(chan struct{})}
+			command := MustNewCommand("hedgedSlotCmd", "testGroup", cmd, options)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "first")
+			_, inFlight := command.circuit.ConcurrencyLimit()
+			So(inFlight, ShouldEqual, 1)
+
+			close(cmd.release)
+			for i := 0; i < 100 && inFlight > 0; i++ {
+				time.Sleep(time.Millisecond)
+				_, inFlight = command.circuit.ConcurrencyLimit()
+			}
+			So(inFlight, ShouldEqual, 0)
+		})
+
+		Convey("Every hedged attempt runs the command of NewAttempt", func() {
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, MaxHedges: 1}
+			command := MustNewCommand("hedgedAttemptCmd", "testGroup", &AttemptCommand{attempts: new(int32)}, options)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "attempt 1")
+		})
+
+		Convey("Fast calls don't launch hedged attempts", func() {
+			options.Hedge = HedgeOptions{Delay: 50 * time.Millisecond, MaxHedges: 1}
+			command := MustNewCommand("notHedgedCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "result")
+			So(command.HealthCounts().Hedges, ShouldEqual, 0)
+		})
+
+		Convey("Hedge without delay is not valid", func() {
+			options.Hedge = HedgeOptions{MaxHedges: 1}
+			_, err := NewCommandWithOptions("invalidHedgeCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Hedge needs a command that implements HedgeInterface", func() {
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, MaxHedges: 1}
+			_, err := NewCommandWithOptions("unsafeHedgeCmd", "testGroup", &NoFallbackCommand{"error"}, options)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "HedgeInterface")
+		})
+	})
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	Convey("Adaptive timeout follows the latency of the command", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.Timeout = time.Second
+		options.AdaptiveTimeout = AdaptiveTimeoutOptions{Percentile: 0.99, Multiplier: 2, Min: 5 * time.Millisecond, Max: 500 * time.Millisecond}
+		command := MustNewCommand("adaptiveCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+
+		Convey("Without samples the fixed timeout is used", func() {
+			So(command.Timeout(), ShouldEqual, time.Second)
+		})
+
+		Convey("With samples it is the percentile by the multiplier", func() {
+			for i := 0; i < 10; i++ {
+				command.Metric().Success(10 * time.Millisecond)
+			}
+			command.HealthCounts() // the successes are in the sample
+			So(command.Timeout(), ShouldEqual, 20*time.Millisecond)
+			So(command.circuit.Timeout(), ShouldEqual, 20*time.Millisecond)
+			So(command.circuit.ToJSON(), ShouldContainSubstring, "\"timeout\" : \"20ms\"")
+		})
+
+		Convey("It is clamped between Min and Max", func() {
+			for i := 0; i < 10; i++ {
+				command.Metric().Success(time.Millisecond)
+			}
+			command.HealthCounts() // the successes are in the sample
+			So(command.Timeout(), ShouldEqual, 5*time.Millisecond)
+
+			for i := 0; i < 10; i++ {
+				command.Metric().Success(time.Second)
+			}
+			command.HealthCounts()
+			So(command.Timeout(), ShouldEqual, 500*time.Millisecond)
+		})
+
+		Convey("Min greater than Max is not valid", func() {
+			options.AdaptiveTimeout = AdaptiveTimeoutOptions{Percentile: 0.99, Min: time.Second, Max: time.Millisecond}
+			_, err := NewCommandWithOptions("invalidAdaptiveCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLatencySample(t *testing.T) {
+	Convey("The latencies are in the sample selected in the options", t, func() {
+		CircuitsReset()
+
+		Convey("The rolling histogram by default", func() {
+			command := MustNewCommand("rollingCmd", "testGroup", &ResultCommand{"result", nil, false}, CommandOptionsForTest())
+			_, ok := command.Metric().Stats().(*sample.RollingHistogram)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("The exponentially decaying sample", func() {
+			options := CommandOptionsForTest()
+			options.LatencySample = SampleExpDecay
+			command := MustNewCommand("expDecayCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+			_, ok := command.Metric().Stats().(*sample.ExpDecaySample)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("The HDR histogram records the tail latencies", func() {
+			options := CommandOptionsForTest()
+			options.LatencySample = SampleHDR
+			command := MustNewCommand("hdrCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+			_, ok := command.Metric().Stats().(*sample.HDRHistogram)
+			So(ok, ShouldBeTrue)
+
+			for i := 0; i < 990; i++ {
+				command.Metric().Success(time.Millisecond)
+			}
+			for i := 0; i < 10; i++ {
+				command.Metric().Success(time.Second)
+			}
+			command.HealthCounts() // the successes are in the sample
+
+			stats := command.Metric().Stats()
+			So(stats.Percentile(0.5), ShouldAlmostEqual, float64(time.Millisecond), float64(time.Millisecond)/100)
+			So(stats.Percentile(0.999), ShouldAlmostEqual, float64(time.Second), float64(time.Second)/100)
+			So(stats.Max(), ShouldEqual, int64(time.Second))
+		})
+	})
+}
@@ -3,12 +3,17 @@ package goHystrix
 import (
 	"fmt"
 	"github.com/dahernan/goHystrix/statsd"
-	"log"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	metricsExporter MetricExport
+	exporterMutex   sync.RWMutex
 )
 
 func init() {
@@ -16,11 +21,29 @@ func init() {
 }
 
 func Exporter() MetricExport {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
 	return metricsExporter
 }
 
+// SetExporter sets the exporter of the metrics, behind an AsyncExport with DefaultExportQueueSize
+// so the exporter never blocks the metrics (NilExport and AsyncExport are set as they are).
+// The previous exporter is closed if it is an io.Closer, an AsyncExport sends its queued events before.
 func SetExporter(export MetricExport) {
+	switch export.(type) {
+	case NilExport, *AsyncExport:
+	default:
+		export = NewAsyncExport(export, DefaultExportQueueSize)
+	}
+
+	exporterMutex.Lock()
+	previous := metricsExporter
 	metricsExporter = export
+	exporterMutex.Unlock()
+
+	if closer, ok := previous.(io.Closer); ok && previous != export {
+		closer.Close()
+	}
 }
 
 type MetricExport interface {
@@ -30,31 +53,138 @@ type MetricExport interface {
 	FallbackError(group string, name string)
 	Timeout(group string, name string)
 	Panic(group string, name string)
+	Hedge(group string, name string)
+	Throttled(group string, name string)
+	Rejected(group string, name string)
 	State(circuits *CircuitHolder)
 }
 
+// ErrorExport is an optional interface of the exporters that use the errors of the calls,
+// the failures, the timeouts and the rejections with an error are exported with these methods
+// instead of Fail, Timeout and Rejected
+type ErrorExport interface {
+	FailWithError(group string, name string, err error)
+	TimeoutWithError(group string, name string, err error)
+	RejectedWithError(group string, name string, err error)
+}
+
+func exportFail(e MetricExport, group string, name string, err error) {
+	if errorExport, ok := e.(ErrorExport); ok && err != nil {
+		errorExport.FailWithError(group, name, err)
+		return
+	}
+	e.Fail(group, name)
+}
+
+func exportTimeout(e MetricExport, group string, name string, err error) {
+	if errorExport, ok := e.(ErrorExport); ok && err != nil {
+		errorExport.TimeoutWithError(group, name, err)
+		return
+	}
+	e.Timeout(group, name)
+}
+
+func exportRejected(e MetricExport, group string, name string, err error) {
+	if errorExport, ok := e.(ErrorExport); ok && err != nil {
+		errorExport.RejectedWithError(group, name, err)
+		return
+	}
+	e.Rejected(group, name)
+}
+
+// The exporters that have goroutines or buffers implement io.Closer,
+// CircuitHolder.Shutdown closes the exporter to flush the metrics and stop the goroutines.
+
+// exportWriter writes the snapshots of an exporter one at a time and closes the io.Writer once,
+// the write errors are logged and the snapshots after Close are dropped
+type exportWriter struct {
+	w        io.Writer
+	exporter string
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func (w *exportWriter) write(b []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	if _, err := w.w.Write(b); err != nil && err != statsd.ErrNotConnected {
+		logEvent(slog.LevelWarn, "exporter.write.failed", w.exporter+": write: "+err.Error(), "exporter", w.exporter, "error", err)
+	}
+}
+
+// Close closes the io.Writer if it is an io.Closer
+func (w *exportWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if closer, ok := w.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 type StatsdExport struct {
 	statsdClient statsd.Statter
 	prefix       string
+	naming       MetricNaming
+
+	// done is closed by Close, the metrics are not sent after it
+	done      chan struct{}
+	closeOnce *sync.Once
 }
 
 type NilExport struct {
 }
 
-func UseStatsd(address string, prefix string, dur time.Duration) {
-	statsdClient, err := statsd.Dial("udp", address)
+// MetricNaming returns the name and the tags of a metric (success, fail, open...) of a command
+type MetricNaming func(prefix string, group string, name string, metric string) (string, []string)
+
+// DefaultNaming is prefix.group.name.metric, without tags
+func DefaultNaming(prefix string, group string, name string, metric string) (string, []string) {
+	return fmt.Sprintf("%s.%s.%s.%s", prefix, group, name, metric), nil
+}
+
+// TaggedNaming shares the names between the commands, prefix.command.metric,
+// and sends the group and the name as DogStatsD tags
+func TaggedNaming(prefix string, group string, name string, metric string) (string, []string) {
+	return fmt.Sprintf("%s.command.%s", prefix, metric), []string{"group:" + group, "name:" + name}
+}
+
+// TemplateNaming builds the name and the tags replacing {prefix}, {group}, {name} and {metric}
+//
+//	TemplateNaming("{prefix}.{metric}", "group:{group}", "command:{name}")
+func TemplateNaming(template string, tags ...string) MetricNaming {
+	return func(prefix string, group string, name string, metric string) (string, []string) {
+		r := strings.NewReplacer("{prefix}", prefix, "{group}", group, "{name}", name, "{metric}", metric)
+		var values []string
+		for _, tag := range tags {
+			values = append(values, r.Replace(tag))
+		}
+		return r.Replace(template), values
+	}
+}
+
+// UseStatsd publishes the metrics in statsd, with DefaultNaming unless there is a naming.
+// The address is "host:port" for udp, or has the protocol like "tcp://host:port" or "unixgram:///path",
+// the connection is dialed when the metrics are sent, so the server can be started later.
+func UseStatsd(address string, prefix string, dur time.Duration, naming ...MetricNaming) {
+	proto, endpoint := statsd.ParseAddress(address)
+	statsdClient, err := statsd.DialBufferedLazy(proto, endpoint, time.Second)
 	if err != nil {
-		log.Println("Error setting Statds for publishing the metrics: ", err)
-		log.Println("Using NilExport for publishing the metrics")
+		logEvent(slog.LevelError, "exporter.failed", "Error setting Statds for publishing the metrics: "+err.Error(), "exporter", "statsd", "error", err)
+		logEvent(slog.LevelWarn, "exporter.disabled", "Using NilExport for publishing the metrics", "exporter", "statsd")
 		SetExporter(NilExport{})
 		return
 	}
-	export := NewStatsdExport(statsdClient, prefix)
-	SetExporter(export)
-
-	statsdExport := export.(StatsdExport)
-	// poll the state of the circuits
-	go statsdExport.run(Circuits(), dur)
+	SetExporter(NewStatsdExport(statsdClient, prefix, naming...))
+	PollState(Circuits(), dur)
 }
 
 func NewNilExport() MetricExport { return NilExport{} }
@@ -65,44 +195,119 @@ func (NilExport) Fallback(group string, name string)                        {}
 func (NilExport) FallbackError(group string, name string)                   {}
 func (NilExport) Timeout(group string, name string)                         {}
 func (NilExport) Panic(group string, name string)                           {}
+func (NilExport) Hedge(group string, name string)                           {}
+func (NilExport) Throttled(group string, name string)                       {}
+func (NilExport) Rejected(group string, name string)                        {}
 func (NilExport) State(circuits *CircuitHolder)                             {}
 
-func NewStatsdExport(statsdClient statsd.Statter, prefix string) MetricExport {
-	return StatsdExport{statsdClient, prefix}
+// NewStatsdExport publishes the metrics with the statsd client, the names are built with
+// the naming (DefaultNaming if there is none)
+func NewStatsdExport(statsdClient statsd.Statter, prefix string, naming ...MetricNaming) MetricExport {
+	n := DefaultNaming
+	if len(naming) > 0 && naming[0] != nil {
+		n = naming[0]
+	}
+	return StatsdExport{
+		statsdClient: statsdClient,
+		prefix:       prefix,
+		naming:       n,
+		done:         make(chan struct{}),
+		closeOnce:    &sync.Once{},
+	}
+}
+
+// send publishes a metric, the ones after Close are dropped.
+// It runs in the caller, SetExporter already exports in its own goroutine.
+func (s StatsdExport) send(f func()) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	f()
+}
+
+// Close closes the statsd client, the metrics after it are dropped
+func (s StatsdExport) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if closer, ok := s.statsdClient.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+// stat returns the client with the tags of the metric, and the name of the metric
+func (s StatsdExport) stat(group string, name string, metric string) (statsd.Statter, string) {
+	bucket, tags := s.naming(s.prefix, group, name, metric)
+	if len(tags) == 0 {
+		return s.statsdClient, bucket
+	}
+	return s.statsdClient.WithTags(tags...), bucket
 }
 
 func (s StatsdExport) Success(group string, name string, duration time.Duration) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.success", s.prefix, group, name), 1)
+	s.send(func() {
+		client, bucket := s.stat(group, name, "success")
+		client.Counter(1.0, bucket, 1)
 		//ms := int64(duration / time.Millisecond)
-		s.statsdClient.Timing(1.0, fmt.Sprintf("%s.%s.%s.duration", s.prefix, group, name), duration)
-	}()
+		client, bucket = s.stat(group, name, "duration")
+		client.Timing(1.0, bucket, duration)
+	})
 }
 
 func (s StatsdExport) Fail(group string, name string) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.fail", s.prefix, group, name), 1)
-	}()
+	s.send(func() {
+		client, bucket := s.stat(group, name, "fail")
+		client.Counter(1.0, bucket, 1)
+	})
 }
 func (s StatsdExport) Fallback(group string, name string) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.fallback", s.prefix, group, name), 1)
-	}()
+	s.send(func() {
+		client, bucket := s.stat(group, name, "fallback")
+		client.Counter(1.0, bucket, 1)
+	})
 }
 func (s StatsdExport) FallbackError(group string, name string) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.fallbackError", s.prefix, group, name), 1)
-	}()
+	s.send(func() {
+		client, bucket := s.stat(group, name, "fallbackError")
+		client.Counter(1.0, bucket, 1)
+	})
 }
 func (s StatsdExport) Timeout(group string, name string) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.timeout", s.prefix, group, name), 1)
-	}()
+	s.send(func() {
+		client, bucket := s.stat(group, name, "timeout")
+		client.Counter(1.0, bucket, 1)
+	})
 }
 func (s StatsdExport) Panic(group string, name string) {
-	go func() {
-		s.statsdClient.Counter(1.0, fmt.Sprintf("%s.%s.%s.panic", s.prefix, group, name), 1)
-	}()
+	s.send(func() {
+		client, bucket := s.stat(group, name, "panic")
+		client.Counter(1.0, bucket, 1)
+	})
+}
+
+func (s StatsdExport) Hedge(group string, name string) {
+	s.send(func() {
+		client, bucket := s.stat(group, name, "hedge")
+		client.Counter(1.0, bucket, 1)
+	})
+}
+
+func (s StatsdExport) Throttled(group string, name string) {
+	s.send(func() {
+		client, bucket := s.stat(group, name, "throttled")
+		client.Counter(1.0, bucket, 1)
+	})
+}
+
+func (s StatsdExport) Rejected(group string, name string) {
+	s.send(func() {
+		client, bucket := s.stat(group, name, "rejected")
+		client.Counter(1.0, bucket, 1)
+	})
 }
 
 func (s StatsdExport) State(holder *CircuitHolder) {
@@ -118,16 +323,25 @@ func (s StatsdExport) State(holder *CircuitHolder) {
 			if open {
 				state = "1"
 			}
-			s.statsdClient.Gauge(1.0, fmt.Sprintf("%s.%s.%s.open", s.prefix, group, name), state)
+			s.gauge(group, name, "open", state)
+			s.gauge(group, name, "timeout", fmt.Sprintf("%d", circuit.Timeout()/time.Millisecond))
+			limit, inFlight := circuit.ConcurrencyLimit()
+			s.gauge(group, name, "concurrencyLimit", fmt.Sprintf("%d", limit))
+			s.gauge(group, name, "inFlight", fmt.Sprintf("%d", inFlight))
+			s.gauge(group, name, "queued", fmt.Sprintf("%d", circuit.QueueDepth()))
+			stats := circuit.Metric().Stats()
+			if stats.Count() > 0 {
+				percentiles := stats.Percentiles([]float64{0.5, 0.9, 0.99})
+				for i, metric := range []string{"percentile50", "percentile90", "percentile99"} {
+					ms := percentiles[i] / float64(time.Millisecond)
+					s.gauge(group, name, metric, strconv.FormatFloat(ms, 'f', -1, 64))
+				}
+			}
 		}
 	}
 }
 
-func (s StatsdExport) run(holder *CircuitHolder, dur time.Duration) {
-	for {
-		select {
-		case <-time.After(dur):
-			s.State(holder)
-		}
-	}
+func (s StatsdExport) gauge(group string, name string, metric string, value string) {
+	client, bucket := s.stat(group, name, metric)
+	client.Gauge(1.0, bucket, value)
 }
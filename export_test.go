@@ -0,0 +1,77 @@
+package goHystrix
+
+import (
+	"github.com/dahernan/goHystrix/statsd"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestMetricNaming(t *testing.T) {
+	Convey("The naming builds the names and the tags of the metrics", t, func() {
+		Convey("DefaultNaming is prefix.group.name.metric", func() {
+			bucket, tags := DefaultNaming("prefix", "group", "name", "success")
+			So(bucket, ShouldEqual, "prefix.group.name.success")
+			So(tags, ShouldBeEmpty)
+		})
+
+		Convey("TaggedNaming shares the name and tags the group and the name", func() {
+			bucket, tags := TaggedNaming("hystrix", "group", "name", "success")
+			So(bucket, ShouldEqual, "hystrix.command.success")
+			So(tags, ShouldResemble, []string{"group:group", "name:name"})
+		})
+
+		Convey("TemplateNaming replaces the placeholders", func() {
+			naming := TemplateNaming("{prefix}.{metric}", "group:{group}", "command:{name}")
+			bucket, tags := naming("hystrix", "db", "users", "fail")
+			So(bucket, ShouldEqual, "hystrix.fail")
+			So(tags, ShouldResemble, []string{"group:db", "command:users"})
+		})
+	})
+
+	Convey("StatsdExport sends the tags in DogStatsD format", t, func() {
+		buffer := &syncBuffer{}
+		client, _ := statsd.New(buffer)
+		export := NewStatsdExport(client, "hystrix", TaggedNaming).(StatsdExport)
+
+		export.Fail("db", "users")
+		export.Success("db", "users", 20*time.Millisecond)
+		So(export.Close(), ShouldBeNil)
+
+		So(buffer.String(), ShouldContainSubstring, "hystrix.command.fail:1|c|#group:db,name:users")
+		So(buffer.String(), ShouldContainSubstring, "hystrix.command.success:1|c|#group:db,name:users")
+		So(buffer.String(), ShouldContainSubstring, "hystrix.command.duration:20|ms|#group:db,name:users")
+	})
+
+	Convey("The default naming doesn't send tags", t, func() {
+		buffer := &syncBuffer{}
+		client, _ := statsd.New(buffer)
+		export := NewStatsdExport(client, "prefix").(StatsdExport)
+
+		export.Fail("db", "users")
+		So(export.Close(), ShouldBeNil)
+		So(buffer.String(), ShouldEqual, "prefix.db.users.fail:1|c")
+	})
+}
+
+func TestStatsdExportState(t *testing.T) {
+	Convey("StatsdExport publishes the state of the circuits as gauges", t, func() {
+		CircuitsReset()
+		buffer := &syncBuffer{}
+		client, _ := statsd.New(buffer)
+		export := NewStatsdExport(client, "prefix").(StatsdExport)
+
+		circuit := NewCircuit("testGroup", "stateCmd", CommandOptionsForTest())
+		So(circuit.Acquire(), ShouldBeTrue)
+		circuit.Metric().Stats().Update(int64(10 * time.Millisecond))
+
+		export.State(Circuits())
+		circuit.Release()
+
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.stateCmd.open:0|g")
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.stateCmd.inFlight:1|g")
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.stateCmd.queued:0|g")
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.stateCmd.percentile50:10|g")
+		So(buffer.String(), ShouldContainSubstring, "prefix.testGroup.stateCmd.percentile99:10|g")
+	})
+}
@@ -0,0 +1,121 @@
+package goHystrix
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dahernan/goHystrix/statsd"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// GraphiteExport writes snapshots of the circuits in the Graphite plaintext protocol,
+// a line per metric of every circuit:
+//
+//	prefix.group.name.metric value timestamp
+//
+// The metrics are the counts of HealthCounts, the effective timeout and the latency percentiles (ms),
+// the concurrency limit, the calls in flight and the open state (0 or 1).
+// The snapshots are taken in State, the single events are not exported.
+type GraphiteExport struct {
+	exportWriter
+	prefix string
+	// now is the time of the snapshots
+	now func() time.Time
+}
+
+// graphiteReplacer sanitises the group and the name, the dots would be new levels of the path
+var graphiteReplacer = strings.NewReplacer(".", "_", " ", "_", "\n", "_", "\t", "_")
+
+// NewGraphiteExport writes the plaintext protocol into w
+func NewGraphiteExport(w io.Writer, prefix string) *GraphiteExport {
+	return &GraphiteExport{
+		exportWriter: exportWriter{w: w, exporter: "graphite"},
+		prefix:       prefix,
+		now:          time.Now,
+	}
+}
+
+// UseGraphite writes the state of the circuits every dur to the Graphite server (host:2003) over tcp,
+// the connection is dialed when the metrics are sent and dialed again after errors.
+func UseGraphite(address string, prefix string, dur time.Duration) {
+	conn, err := statsd.NewConn("tcp", address)
+	if err != nil {
+		logEvent(slog.LevelError, "exporter.failed", "Error setting Graphite for publishing the metrics: "+err.Error(), "exporter", "graphite", "error", err)
+		logEvent(slog.LevelWarn, "exporter.disabled", "Using NilExport for publishing the metrics", "exporter", "graphite")
+		SetExporter(NilExport{})
+		return
+	}
+	SetExporter(NewGraphiteExport(conn, prefix))
+	PollState(Circuits(), dur)
+}
+
+func (e *GraphiteExport) Success(group string, name string, duration time.Duration) {}
+func (e *GraphiteExport) Fail(group string, name string)                            {}
+func (e *GraphiteExport) Fallback(group string, name string)                        {}
+func (e *GraphiteExport) FallbackError(group string, name string)                   {}
+func (e *GraphiteExport) Timeout(group string, name string)                         {}
+func (e *GraphiteExport) Panic(group string, name string)                           {}
+func (e *GraphiteExport) Hedge(group string, name string)                           {}
+func (e *GraphiteExport) Throttled(group string, name string)                       {}
+func (e *GraphiteExport) Rejected(group string, name string)                        {}
+
+// State writes the metrics of every circuit, sorted by group and name
+func (e *GraphiteExport) State(holder *CircuitHolder) {
+	timestamp := e.now().Unix()
+
+	var buffer bytes.Buffer
+	for _, circuit := range holder.sortedCircuits() {
+		e.writeCircuit(&buffer, circuit, timestamp)
+	}
+	if buffer.Len() == 0 {
+		return
+	}
+	e.write(buffer.Bytes())
+}
+
+func (e *GraphiteExport) writeCircuit(buffer *bytes.Buffer, circuit *CircuitBreaker, timestamp int64) {
+	path := fmt.Sprintf("%s.%s.%s", e.prefix, graphiteReplacer.Replace(circuit.group), graphiteReplacer.Replace(circuit.name))
+	if e.prefix == "" {
+		path = path[1:]
+	}
+	line := func(metric string, value interface{}) {
+		fmt.Fprintf(buffer, "%s.%s %v %d\n", path, metric, value, timestamp)
+	}
+
+	open, _ := circuit.IsOpen()
+	state := 0
+	if open {
+		state = 1
+	}
+	counts := circuit.Metric().HealthCounts()
+	stats := circuit.Metric().Stats()
+
+	line("open", state)
+	line("total", counts.Total)
+	line("success", counts.Success)
+	line("failures", counts.Failures)
+	line("timeouts", counts.Timeouts)
+	line("fallback", counts.Fallback)
+	line("fallbackErrors", counts.FallbackErrors)
+	line("panics", counts.Panics)
+	line("hedges", counts.Hedges)
+	line("throttled", counts.Throttled)
+	line("rejected", counts.Rejected)
+	line("errorPercentage", formatFloat(counts.ErrorPercentage))
+	// the effective timeout in ms, the adaptive one if it is set
+	line("timeout", formatFloat(float64(circuit.Timeout())/float64(time.Millisecond)))
+	limit, inFlight := circuit.ConcurrencyLimit()
+	line("concurrencyLimit", limit)
+	line("inFlight", inFlight)
+
+	if stats.Count() > 0 {
+		// the latencies in ms
+		percentiles := stats.Percentiles([]float64{0.5, 0.9, 0.99})
+		line("p50", formatFloat(percentiles[0]/float64(time.Millisecond)))
+		line("p90", formatFloat(percentiles[1]/float64(time.Millisecond)))
+		line("p99", formatFloat(percentiles[2]/float64(time.Millisecond)))
+		line("mean", formatFloat(stats.Mean()/float64(time.Millisecond)))
+	}
+}
@@ -0,0 +1,93 @@
+package goHystrix
+
+import (
+	"bufio"
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphiteExport(t *testing.T) {
+	Convey("GraphiteExport writes the state of the circuits in plaintext protocol", t, func() {
+		CircuitsReset()
+		buffer := &syncBuffer{}
+		export := NewGraphiteExport(buffer, "prefix")
+		export.now = func() time.Time { return time.Unix(1414245245, 0) }
+
+		command := MustNewCommand("graphite.cmd", "test group", &MyStringCommand{"hello"}, CommandOptionsForTest())
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+		circuit, _ := Circuits().Get("test group", "graphite.cmd")
+		circuit.Metric().HealthCounts() // the success is in the sample
+		circuit.Metric().Stats().Clear()
+		circuit.Metric().Stats().Update(int64(12500 * time.Microsecond))
+
+		export.State(Circuits())
+
+		So(buffer.String(), ShouldEqual, strings.Join([]string{
+			"prefix.test_group.graphite_cmd.open 0 1414245245",
+			"prefix.test_group.graphite_cmd.total 1 1414245245",
+			"prefix.test_group.graphite_cmd.success 1 1414245245",
+			"prefix.test_group.graphite_cmd.failures 0 1414245245",
+			"prefix.test_group.graphite_cmd.timeouts 0 1414245245",
+			"prefix.test_group.graphite_cmd.fallback 0 1414245245",
+			"prefix.test_group.graphite_cmd.fallbackErrors 0 1414245245",
+			"prefix.test_group.graphite_cmd.panics 0 1414245245",
+			"prefix.test_group.graphite_cmd.hedges 0 1414245245",
+			"prefix.test_group.graphite_cmd.throttled 0 1414245245",
+			"prefix.test_group.graphite_cmd.rejected 0 1414245245",
+			"prefix.test_group.graphite_cmd.errorPercentage 0 1414245245",
+			"prefix.test_group.graphite_cmd.timeout 3 1414245245",
+			"prefix.test_group.graphite_cmd.concurrencyLimit 0 1414245245",
+			"prefix.test_group.graphite_cmd.inFlight 0 1414245245",
+			"prefix.test_group.graphite_cmd.p50 12.5 1414245245",
+			"prefix.test_group.graphite_cmd.p90 12.5 1414245245",
+			"prefix.test_group.graphite_cmd.p99 12.5 1414245245",
+			"prefix.test_group.graphite_cmd.mean 12.5 1414245245",
+			"",
+		}, "\n"))
+	})
+
+	Convey("Without prefix the path starts with the group", t, func() {
+		CircuitsReset()
+		buffer := &syncBuffer{}
+		export := NewGraphiteExport(buffer, "")
+		NewCircuit("testGroup", "cmd", CommandOptionsForTest())
+
+		export.State(Circuits())
+		So(buffer.String(), ShouldStartWith, "testGroup.cmd.open 0 ")
+	})
+
+	Convey("UseGraphite sends the snapshots over tcp", t, func() {
+		CircuitsReset()
+		defer SetExporter(NewNilExport())
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		lines := make(chan string, 100)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+
+		NewCircuit("testGroup", "tcpCmd", CommandOptionsForTest())
+		UseGraphite(l.Addr().String(), "prefix", time.Millisecond)
+
+		select {
+		case line := <-lines:
+			So(line, ShouldStartWith, "prefix.testGroup.tcpCmd.open 0 ")
+		case <-time.After(2 * time.Second):
+			So("no lines", ShouldBeNil)
+		}
+		So(Circuits().Shutdown(context.Background()), ShouldBeNil)
+	})
+}
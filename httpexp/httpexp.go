@@ -1,6 +1,7 @@
 package httpexp
 
 import (
+	"expvar"
 	"fmt"
 	"github.com/dahernan/goHystrix"
 	"net/http"
@@ -13,4 +14,6 @@ func expvarHandler(w http.ResponseWriter, r *http.Request) {
 
 func init() {
 	http.HandleFunc("/debug/circuits", expvarHandler)
+	// the snapshot of the circuits in /debug/vars, next to memstats
+	expvar.Publish("circuits", goHystrix.CircuitsVar{})
 }
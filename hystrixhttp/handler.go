@@ -0,0 +1,156 @@
+package hystrixhttp
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dahernan/goHystrix"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handler is a http.Handler middleware that sheds load with the circuit of every route.
+// When the request is over the RateLimit, the circuit is open or the concurrency limit is reached
+// it responds 503 with Retry-After,
+// otherwise it serves the request and records the latency and the status class in the Metric,
+// 5xx responses and panics count as failures.
+//
+//	handler, err := hystrixhttp.NewHandler(mux, hystrixhttp.ByPath("api"), &goHystrix.CommandOptions{MaxConcurrentRequests: 100})
+//	http.Handle("/", handler)
+//
+// The circuits of a Handler built without NewHandler take the default options if Options are not valid.
+type Handler struct {
+	Handler http.Handler
+	// Route maps the request to the command, ByPath("http") if nil
+	Route RouteFunc
+	// Options for the commands, goHystrix.CommandOptionsFor(group, name) if nil
+	Options *goHystrix.CommandOptions
+	// RetryAfter is sent in the Retry-After header of the rejected requests, 1 second if 0
+	RetryAfter time.Duration
+}
+
+// NewHandler returns a Handler for the handler, or an error if the options are not valid
+func NewHandler(handler http.Handler, route RouteFunc, options *goHystrix.CommandOptions) (*Handler, error) {
+	if options != nil {
+		if err := options.WithDefaults().Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Handler{Handler: handler, Route: route, Options: options}, nil
+}
+
+// ByPath uses the path of the request as the name of the command
+func ByPath(group string) RouteFunc {
+	return func(req *http.Request) (string, string) {
+		return group, req.URL.Path
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	route := h.Route
+	if route == nil {
+		route = ByPath("http")
+	}
+	group, name := route(req)
+
+	options := goHystrix.CommandOptionsFor(group, name)
+	if h.Options != nil {
+		options = *h.Options
+	}
+	circuit := goHystrix.NewCircuit(group, name, options)
+	metric := circuit.Metric()
+
+	// the same checks as the commands
+	if !circuit.Allow() {
+		metric.Throttled()
+		metric.Fallback()
+		h.reject(w, goHystrix.ErrThrottled)
+		return
+	}
+	open, _ := circuit.IsOpen()
+	if open {
+		metric.Fallback()
+		h.reject(w, goHystrix.ErrCircuitOpen)
+		return
+	}
+	if !circuit.Acquire() {
+		metric.Rejected()
+		metric.Fallback()
+		h.reject(w, goHystrix.ErrMaxConcurrency)
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			circuit.ReleaseWithLatency(time.Since(start), false)
+			metric.Panic()
+			metric.Fail()
+			metric.Status(http.StatusInternalServerError)
+			panic(r)
+		}
+	}()
+
+	h.Handler.ServeHTTP(sw, req)
+
+	circuit.ReleaseWithLatency(time.Since(start), false)
+	metric.Status(sw.status)
+	if sw.status >= 500 {
+		metric.Fail()
+	} else {
+		metric.Success(time.Since(start))
+	}
+}
+
+func (h *Handler) reject(w http.ResponseWriter, err error) {
+	retryAfter := h.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, fmt.Sprintf("Service Unavailable: %s", err.Error()), http.StatusServiceUnavailable)
+}
+
+// statusWriter keeps the status code of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is for the websockets, the status of the hijacked connections is the one before Hijack
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hystrixhttp: %T is not a http.Hijacker", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the original ResponseWriter, for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
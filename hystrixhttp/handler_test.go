@@ -0,0 +1,148 @@
+package hystrixhttp
+
+import (
+	"github.com/dahernan/goHystrix"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	Convey("Handler wraps every route in a command", t, func() {
+		goHystrix.CircuitsReset()
+
+		release := make(chan struct{})
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+		mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "boom", 500) })
+		mux.HandleFunc("/blocked", func(w http.ResponseWriter, r *http.Request) { <-release })
+
+		options := optionsForTest()
+		options.MaxConcurrentRequests = 1
+		handler := &Handler{Handler: mux, Route: ByPath("api"), Options: options, RetryAfter: 5 * time.Second}
+
+		serve := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("Records the status classes and the latency", func() {
+			So(serve("/ok").Code, ShouldEqual, 200)
+			So(serve("/missing").Code, ShouldEqual, 404)
+
+			counts := goHystrix.NewCircuit("api", "/ok", *options).Metric().HealthCounts()
+			So(counts.Success, ShouldEqual, 1)
+			So(counts.StatusClasses[2], ShouldEqual, 1)
+
+			counts = goHystrix.NewCircuit("api", "/missing", *options).Metric().HealthCounts()
+			So(counts.Success, ShouldEqual, 1)
+			So(counts.StatusClasses[4], ShouldEqual, 1)
+		})
+
+		Convey("5xx open the circuit and the next requests get 503", func() {
+			for i := 0; i < 3; i++ {
+				So(serve("/error").Code, ShouldEqual, 500)
+			}
+			w := serve("/error")
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+
+			counts := goHystrix.NewCircuit("api", "/error", *options).Metric().HealthCounts()
+			So(counts.Failures, ShouldEqual, 3)
+			So(counts.StatusClasses[5], ShouldEqual, 3)
+			So(counts.Fallback, ShouldEqual, 1)
+		})
+
+		Convey("Requests over the rate limit get 503", func() {
+			limited := *options
+			limited.RateLimit = goHystrix.RateLimitOptions{Rate: 1, Burst: 1}
+			handler.Options = &limited
+
+			So(serve("/ok").Code, ShouldEqual, 200)
+			w := serve("/ok")
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+
+			counts := goHystrix.NewCircuit("api", "/ok", limited).Metric().HealthCounts()
+			So(counts.Throttled, ShouldEqual, 1)
+			So(counts.Fallback, ShouldEqual, 1)
+		})
+
+		Convey("Requests over the concurrency limit get 503", func() {
+			done := make(chan int)
+			go func() { done <- serve("/blocked").Code }()
+
+			circuit := goHystrix.NewCircuit("api", "/blocked", *options)
+			for _, inFlight := circuit.ConcurrencyLimit(); inFlight == 0; _, inFlight = circuit.ConcurrencyLimit() {
+				time.Sleep(time.Millisecond)
+			}
+
+			w := serve("/blocked")
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "5")
+
+			close(release)
+			So(<-done, ShouldEqual, 200)
+		})
+	})
+}
+
+func TestNewHandler(t *testing.T) {
+	Convey("NewHandler validates the options", t, func() {
+		goHystrix.CircuitsReset()
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+
+		_, err := NewHandler(ok, ByPath("api"), &goHystrix.CommandOptions{NumberOfSecondsToStore: -1})
+		So(err, ShouldNotBeNil)
+
+		handler, err := NewHandler(ok, ByPath("api"), optionsForTest())
+		So(err, ShouldBeNil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/ok", nil))
+		So(w.Code, ShouldEqual, 200)
+
+		Convey("A Handler with invalid options uses the defaults", func() {
+			invalid := &Handler{Handler: ok, Options: &goHystrix.CommandOptions{NumberOfSecondsToStore: -1}}
+			w := httptest.NewRecorder()
+			So(func() { invalid.ServeHTTP(w, httptest.NewRequest("GET", "/invalid", nil)) }, ShouldNotPanic)
+			So(w.Code, ShouldEqual, 200)
+		})
+	})
+}
+
+func TestHandlerResponseWriter(t *testing.T) {
+	Convey("The ResponseWriter of the handlers can be hijacked and unwrapped", t, func() {
+		goHystrix.CircuitsReset()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+			conn, buffer, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			defer conn.Close()
+			buffer.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+			buffer.Flush()
+		})
+		mux.HandleFunc("/deadline", func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Write([]byte("deadline"))
+		})
+		server := httptest.NewServer(&Handler{Handler: mux, Route: ByPath("api"), Options: optionsForTest()})
+		defer server.Close()
+
+		for path, expected := range map[string]string{"/hijack": "hijacked", "/deadline": "deadline"} {
+			resp, err := http.Get(server.URL + path)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(body(resp), ShouldEqual, expected)
+		}
+	})
+}
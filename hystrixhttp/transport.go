@@ -0,0 +1,223 @@
+package hystrixhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dahernan/goHystrix"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ErrNilResponse is the error of the requests when the Fallback returns neither a response nor an error
+var ErrNilResponse = errors.New("hystrixhttp: the fallback returned a nil response")
+
+// RouteFunc maps a request to the group and name of its command
+type RouteFunc func(req *http.Request) (group string, name string)
+
+// FallbackFunc returns the response used when the request fails or the circuit is open,
+// err is the error of the request or goHystrix.ErrCircuitOpen
+type FallbackFunc func(req *http.Request, err error) (*http.Response, error)
+
+// Transport is a http.RoundTripper that executes every request as a goHystrix command.
+// Transport errors and 5xx responses count as failures, 4xx responses as success.
+//
+//	client := &http.Client{Transport: &hystrixhttp.Transport{Route: hystrixhttp.ByHost("api")}}
+type Transport struct {
+	// Transport is the RoundTripper that does the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// Route maps the request to the command, ByHost("http") if nil
+	Route RouteFunc
+	// Options for the commands, goHystrix.CommandOptionsFor(group, name) if nil, Hedge is ignored
+	Options *goHystrix.CommandOptions
+	// Fallback is optional, without fallback the 5xx responses are returned as they are,
+	// the open circuit returns goHystrix.ErrCircuitOpen and the rest of failures return an error
+	Fallback FallbackFunc
+}
+
+// ByHost uses the host of the request as the name of the command
+func ByHost(group string) RouteFunc {
+	return func(req *http.Request) (string, string) {
+		return group, req.URL.Host
+	}
+}
+
+// ByHeader uses the value of the header as the name of the command, or the host if the header is empty
+func ByHeader(group string, header string) RouteFunc {
+	return func(req *http.Request) (string, string) {
+		name := req.Header.Get(header)
+		if name == "" {
+			name = req.URL.Host
+		}
+		return group, name
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := t.Route
+	if route == nil {
+		route = ByHost("http")
+	}
+	group, name := route(req)
+
+	options := goHystrix.CommandOptionsFor(group, name)
+	if t.Options != nil {
+		options = *t.Options
+	}
+	// the attempts would share the request and its body, they are never hedged
+	options.Hedge = goHystrix.HedgeOptions{}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	rt := &roundTrip{transport: transport, req: req.WithContext(ctx)}
+
+	var command goHystrix.Interface = rt
+	if t.Fallback != nil {
+		command = &roundTripFallback{roundTrip: rt, fallback: t.Fallback, origReq: req}
+	}
+
+	cmd, err := goHystrix.NewCommandWithOptions(name, group, command, options)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	value, err := cmd.Execute()
+	resp, _ := value.(*http.Response)
+
+	if err != nil && t.Fallback == nil {
+		if errors.Is(err, goHystrix.ErrCircuitOpen) {
+			// the same error the fallback gets
+			err = goHystrix.ErrCircuitOpen
+		}
+		// without fallback the client gets the 5xx response
+		resp = rt.failedResponse()
+		if resp != nil {
+			err = nil
+		}
+	}
+
+	if resp != nil && rt.owns(resp) {
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+
+	rt.abandon()
+	cancel()
+	if resp == nil && err == nil {
+		// a RoundTripper returns a response or an error
+		err = ErrNilResponse
+	}
+	return resp, err
+}
+
+// roundTrip is the command for one request
+type roundTrip struct {
+	transport http.RoundTripper
+	req       *http.Request
+
+	mutex     sync.Mutex
+	resp      *http.Response
+	err       error
+	started   bool
+	abandoned bool
+}
+
+func (rt *roundTrip) Run() (interface{}, error) {
+	rt.mutex.Lock()
+	rt.started = true
+	rt.mutex.Unlock()
+
+	resp, err := rt.transport.RoundTrip(rt.req)
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.abandoned {
+		// the command timed out, nobody is going to read the response
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("%s %s: abandoned", rt.req.Method, rt.req.URL)
+	}
+	if err != nil {
+		rt.err = err
+		return nil, err
+	}
+
+	rt.resp = resp
+	if resp.StatusCode >= 500 {
+		rt.err = fmt.Errorf("%s %s: %s", rt.req.Method, rt.req.URL, resp.Status)
+		return nil, rt.err
+	}
+	return resp, nil
+}
+
+// failedResponse returns the 5xx response, if any
+func (rt *roundTrip) failedResponse() *http.Response {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.resp != nil && rt.resp.StatusCode >= 500 {
+		return rt.resp
+	}
+	return nil
+}
+
+func (rt *roundTrip) owns(resp *http.Response) bool {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	return rt.resp == resp
+}
+
+// runError returns the error to pass to the fallback
+func (rt *roundTrip) runError() error {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if !rt.started {
+		return goHystrix.ErrCircuitOpen
+	}
+	if rt.err == nil {
+		return fmt.Errorf("%s %s: timeout", rt.req.Method, rt.req.URL)
+	}
+	return rt.err
+}
+
+// abandon closes the response that is not going to be returned
+func (rt *roundTrip) abandon() {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.abandoned = true
+	if rt.resp != nil {
+		rt.resp.Body.Close()
+	}
+}
+
+type roundTripFallback struct {
+	*roundTrip
+	fallback FallbackFunc
+	origReq  *http.Request
+}
+
+func (rt *roundTripFallback) Fallback() (interface{}, error) {
+	resp, err := rt.fallback(rt.origReq, rt.runError())
+	if resp == nil && err == nil {
+		return nil, ErrNilResponse
+	}
+	return resp, err
+}
+
+// cancelBody releases the context of the request when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,193 @@
+package hystrixhttp
+
+import (
+	"errors"
+	"github.com/dahernan/goHystrix"
+	"github.com/dahernan/goHystrix/internal/hystrixtest"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func optionsForTest() *goHystrix.CommandOptions {
+	return hystrixtest.Options(50 * time.Millisecond)
+}
+
+func newServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("ok"))
+		case "/notfound":
+			http.NotFound(w, r)
+		case "/error":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("slow"))
+		}
+	}))
+}
+
+func fallbackForTest(req *http.Request, err error) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("fallback: " + err.Error())),
+		Request:    req,
+	}, nil
+}
+
+func body(resp *http.Response) string {
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestTransport(t *testing.T) {
+	Convey("Transport executes the requests as commands", t, func() {
+		goHystrix.CircuitsReset()
+		server := newServer()
+		defer server.Close()
+
+		route := func(req *http.Request) (string, string) { return "http", req.URL.Path }
+		client := &http.Client{Transport: &Transport{Route: route, Options: optionsForTest()}}
+
+		Convey("2xx and 4xx responses are success", func() {
+			resp, err := client.Get(server.URL + "/ok")
+			So(err, ShouldBeNil)
+			So(body(resp), ShouldEqual, "ok")
+
+			for i := 0; i < 5; i++ {
+				resp, err = client.Get(server.URL + "/notfound")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+				body(resp)
+			}
+
+			circuit, ok := goHystrix.Circuits().Get("http", "/notfound")
+			So(ok, ShouldBeTrue)
+			So(circuit.Metric().HealthCounts().Success, ShouldEqual, 5)
+			open, _ := circuit.IsOpen()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("5xx responses are failures and open the circuit", func() {
+			for i := 0; i < 3; i++ {
+				resp, err := client.Get(server.URL + "/error")
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusInternalServerError)
+				body(resp)
+			}
+			circuit, _ := goHystrix.Circuits().Get("http", "/error")
+			So(circuit.Metric().HealthCounts().Failures, ShouldEqual, 3)
+
+			_, err := client.Get(server.URL + "/error")
+			So(errors.Is(err, goHystrix.ErrCircuitOpen), ShouldBeTrue)
+			So(circuit.Metric().HealthCounts().Total, ShouldEqual, 3)
+		})
+
+		Convey("Transport errors are failures", func() {
+			_, err := client.Get("http://127.0.0.1:1/down")
+			So(err, ShouldNotBeNil)
+			circuit, _ := goHystrix.Circuits().Get("http", "/down")
+			So(circuit.Metric().HealthCounts().Failures, ShouldEqual, 1)
+		})
+
+		Convey("Hedge in the options is ignored", func() {
+			options := optionsForTest()
+			options.Hedge = goHystrix.HedgeOptions{Delay: time.Millisecond, MaxHedges: 1}
+			hedged := &http.Client{Transport: &Transport{Route: route, Options: options}}
+
+			resp, err := hedged.Get(server.URL + "/ok")
+			So(err, ShouldBeNil)
+			So(body(resp), ShouldEqual, "ok")
+		})
+
+		Convey("Slow requests time out", func() {
+			_, err := client.Get(server.URL + "/slow")
+			So(err, ShouldNotBeNil)
+			circuit, _ := goHystrix.Circuits().Get("http", "/slow")
+			So(circuit.Metric().HealthCounts().Timeouts, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Transport with fallback", t, func() {
+		goHystrix.CircuitsReset()
+		server := newServer()
+		defer server.Close()
+
+		route := func(req *http.Request) (string, string) { return "http", req.URL.Path }
+		client := &http.Client{Transport: &Transport{Route: route, Options: optionsForTest(), Fallback: fallbackForTest}}
+
+		Convey("5xx responses are replaced by the fallback", func() {
+			resp, err := client.Get(server.URL + "/error")
+			So(err, ShouldBeNil)
+			So(body(resp), ShouldStartWith, "fallback: GET")
+		})
+
+		Convey("Timeouts use the fallback", func() {
+			resp, err := client.Get(server.URL + "/slow")
+			So(err, ShouldBeNil)
+			So(body(resp), ShouldEndWith, "timeout")
+		})
+
+		Convey("Open circuit uses the fallback with ErrCircuitOpen", func() {
+			for i := 0; i < 3; i++ {
+				body(mustGet(client, server.URL+"/error"))
+			}
+			resp, err := client.Get(server.URL + "/error")
+			So(err, ShouldBeNil)
+			So(body(resp), ShouldEqual, "fallback: "+goHystrix.ErrCircuitOpen.Error())
+		})
+
+		Convey("Fallback errors are returned", func() {
+			failing := &Transport{Route: route, Options: optionsForTest(), Fallback: func(req *http.Request, err error) (*http.Response, error) {
+				return nil, errors.New("no fallback today")
+			}}
+			_, err := (&http.Client{Transport: failing}).Get(server.URL + "/error")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "no fallback today")
+		})
+
+		Convey("A nil response of the fallback is an error", func() {
+			nilFallback := &Transport{Route: route, Options: optionsForTest(), Fallback: func(req *http.Request, err error) (*http.Response, error) {
+				return nil, nil
+			}}
+			req, _ := http.NewRequest("GET", server.URL+"/error", nil)
+			resp, err := nilFallback.RoundTrip(req)
+			So(resp, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrNilResponse.Error())
+		})
+	})
+}
+
+func TestRoutes(t *testing.T) {
+	Convey("Routes map the request to group and name", t, func() {
+		req, _ := http.NewRequest("GET", "http://example.com:8080/path", nil)
+
+		group, name := ByHost("api")(req)
+		So(group, ShouldEqual, "api")
+		So(name, ShouldEqual, "example.com:8080")
+
+		group, name = ByHeader("api", "X-Command")(req)
+		So(name, ShouldEqual, "example.com:8080")
+
+		req.Header.Set("X-Command", "users")
+		group, name = ByHeader("api", "X-Command")(req)
+		So(group, ShouldEqual, "api")
+		So(name, ShouldEqual, "users")
+	})
+}
+
+func mustGet(client *http.Client, url string) *http.Response {
+	resp, err := client.Get(url)
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
@@ -0,0 +1,111 @@
+package hystrixnet
+
+import (
+	"context"
+	"github.com/dahernan/goHystrix"
+	"net"
+	"time"
+)
+
+// Dialer dials with a circuit per network and address (the group is the network and the name the address).
+// It fails fast with goHystrix.ErrCircuitOpen while the circuit is open, and it records
+// the dial latency and the connection failures in the Metric of the circuit.
+// The dials cancelled by the context of the caller are not failures of the circuit.
+//
+//	dialer := &hystrixnet.Dialer{WrapConn: true}
+//	client := redis.NewClient(&redis.Options{Addr: "redis:6379", Dialer: dialer.DialContext})
+type Dialer struct {
+	// Dialer does the connections, a zero net.Dialer if nil
+	Dialer *net.Dialer
+	// Options for the circuits, goHystrix.CommandOptionsFor(network, address) if nil,
+	// Timeout is the timeout of the dial, Hedge is ignored
+	Options *goHystrix.CommandOptions
+	// WrapConn makes the read and write timeouts of the connections count as failures
+	WrapConn bool
+}
+
+var (
+	defaultDialer = &Dialer{}
+)
+
+// DialContext dials with the default Dialer
+func DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	return defaultDialer.DialContext(ctx, network, address)
+}
+
+func (d *Dialer) Dial(network string, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *Dialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	options := goHystrix.CommandOptionsFor(network, address)
+	if d.Options != nil {
+		options = *d.Options
+	}
+	// every dial is one connection, they are never hedged
+	options.Hedge = goHystrix.HedgeOptions{}
+	options = options.WithDefaults()
+	circuit, err := goHystrix.NewCircuitWithOptions(network, address, options)
+	if err != nil {
+		return nil, err
+	}
+
+	open, _ := circuit.IsOpen()
+	if open {
+		return nil, goHystrix.ErrCircuitOpen
+	}
+
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	c, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		if parent.Err() != nil {
+			// the caller gave up, it says nothing about the address
+			return nil, err
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			circuit.Metric().TimeoutWithError(err)
+		} else {
+			circuit.Metric().FailWithError(err)
+		}
+		return nil, err
+	}
+	circuit.Metric().Success(time.Since(start))
+
+	if d.WrapConn {
+		return &conn{Conn: c, metric: circuit.Metric()}, nil
+	}
+	return c, nil
+}
+
+// conn counts the read and write timeouts as failures
+type conn struct {
+	net.Conn
+	metric *goHystrix.Metric
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.check(err)
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.check(err)
+	return n, err
+}
+
+func (c *conn) check(err error) {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.metric.Timeout()
+	}
+}
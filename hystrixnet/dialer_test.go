@@ -0,0 +1,115 @@
+package hystrixnet
+
+import (
+	"context"
+	"github.com/dahernan/goHystrix"
+	"github.com/dahernan/goHystrix/internal/hystrixtest"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func optionsForTest() *goHystrix.CommandOptions {
+	return hystrixtest.Options(100 * time.Millisecond)
+}
+
+func TestDialer(t *testing.T) {
+	Convey("Dialer has a circuit per network and address", t, func() {
+		goHystrix.CircuitsReset()
+		dialer := &Dialer{Options: optionsForTest(), WrapConn: true}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		go func() {
+			var conns []net.Conn
+			for {
+				c, err := listener.Accept()
+				if err != nil {
+					for _, c := range conns {
+						c.Close()
+					}
+					return
+				}
+				conns = append(conns, c)
+			}
+		}()
+
+		Convey("Successful dials record the latency", func() {
+			c, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer c.Close()
+
+			circuit, ok := goHystrix.Circuits().Get("tcp", listener.Addr().String())
+			So(ok, ShouldBeTrue)
+			So(circuit.Metric().HealthCounts().Success, ShouldEqual, 1)
+		})
+
+		Convey("Read deadlines count as failures", func() {
+			c, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer c.Close()
+
+			c.SetReadDeadline(time.Now().Add(time.Millisecond))
+			_, err = c.Read(make([]byte, 1))
+			So(err, ShouldNotBeNil)
+
+			circuit, _ := goHystrix.Circuits().Get("tcp", listener.Addr().String())
+			So(circuit.Metric().HealthCounts().Timeouts, ShouldEqual, 1)
+		})
+
+		Convey("Connection failures open the circuit", func() {
+			closed, _ := net.Listen("tcp", "127.0.0.1:0")
+			address := closed.Addr().String()
+			closed.Close()
+
+			for i := 0; i < 3; i++ {
+				_, err := dialer.Dial("tcp", address)
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, goHystrix.ErrCircuitOpen)
+			}
+
+			_, err := dialer.Dial("tcp", address)
+			So(err, ShouldEqual, goHystrix.ErrCircuitOpen)
+
+			circuit, _ := goHystrix.Circuits().Get("tcp", address)
+			So(circuit.Metric().HealthCounts().Failures, ShouldEqual, 3)
+		})
+
+		Convey("Dials cancelled by the caller are not failures", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := dialer.DialContext(ctx, "tcp", listener.Addr().String())
+			So(err, ShouldNotBeNil)
+
+			circuit, _ := goHystrix.Circuits().Get("tcp", listener.Addr().String())
+			counts := circuit.Metric().HealthCounts()
+			So(counts.Failures+counts.Timeouts, ShouldEqual, 0)
+		})
+
+		Convey("Options without Timeout dial with the default timeout", func() {
+			var deadline time.Time
+			dialer := &Dialer{Options: &goHystrix.CommandOptions{}, Dialer: &net.Dialer{
+				ControlContext: func(ctx context.Context, network string, address string, c syscall.RawConn) error {
+					deadline, _ = ctx.Deadline()
+					return nil
+				},
+			}}
+			c, err := dialer.Dial("tcp", listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(time.Until(deadline), ShouldBeBetween, 0, goHystrix.CommandOptionsDefaults().Timeout)
+		})
+
+		Convey("Invalid options are an error", func() {
+			dialer := &Dialer{Options: &goHystrix.CommandOptions{NumberOfSecondsToStore: -1}}
+			_, err := dialer.Dial("tcp", listener.Addr().String())
+			So(err, ShouldNotBeNil)
+
+			_, ok := goHystrix.Circuits().Get("tcp", listener.Addr().String())
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,150 @@
+package hystrixrpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dahernan/goHystrix"
+	"net/rpc"
+	"reflect"
+	"sync"
+)
+
+// FallbackFunc fills the reply when the call fails or the circuit is open,
+// err is the error of the call or goHystrix.ErrCircuitOpen
+type FallbackFunc func(serviceMethod string, args interface{}, reply interface{}, err error) error
+
+// Client wraps a *rpc.Client, every Call runs as a goHystrix command named after the "Service.Method".
+// The reply is only written when the call succeeds in time, so it is safe to use after a timeout.
+type Client struct {
+	client *rpc.Client
+	group  string
+
+	// Options for the commands, goHystrix.CommandOptionsFor(group, serviceMethod) if nil, Hedge is ignored
+	Options *goHystrix.CommandOptions
+	// Fallback is optional, without fallback the errors are returned and the open circuit returns goHystrix.ErrCircuitOpen
+	Fallback FallbackFunc
+}
+
+func NewClient(client *rpc.Client, group string) *Client {
+	return &Client{client: client, group: group}
+}
+
+// Call invokes the named function, waits for it to complete, and returns its error status
+func (c *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	options := goHystrix.CommandOptionsFor(c.group, serviceMethod)
+	if c.Options != nil {
+		options = *c.Options
+	}
+	// the attempts would write the same reply, they are never hedged
+	options.Hedge = goHystrix.HedgeOptions{}
+
+	cmd := &call{client: c.client, serviceMethod: serviceMethod, args: args, reply: reply}
+	var command goHystrix.Interface = cmd
+	if c.Fallback != nil {
+		command = &callFallback{call: cmd, fallback: c.Fallback}
+	}
+
+	hc, err := goHystrix.NewCommandWithOptions(serviceMethod, c.group, command, options)
+	if err != nil {
+		return err
+	}
+
+	value, err := hc.Execute()
+	if err != nil {
+		if c.Fallback == nil && errors.Is(err, goHystrix.ErrCircuitOpen) {
+			// the same error the fallback gets
+			return goHystrix.ErrCircuitOpen
+		}
+		if callErr := cmd.callError(); c.Fallback == nil && callErr != nil {
+			// errors like rpc.ErrShutdown are returned as they are
+			return callErr
+		}
+		return err
+	}
+	if value != nil {
+		// the reply of the call, the fallback writes the reply itself
+		reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(value).Elem())
+	}
+	return nil
+}
+
+// Go invokes the function asynchronously, like rpc.Client.Go
+func (c *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 10)
+	} else if cap(done) == 0 {
+		panic("hystrixrpc: done channel is unbuffered")
+	}
+
+	rc := &rpc.Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	go func() {
+		rc.Error = c.Call(serviceMethod, args, reply)
+		rc.Done <- rc
+	}()
+	return rc
+}
+
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// call is the command for one rpc call, it decodes into a new reply
+// that is copied to the caller's reply if the command succeeds
+type call struct {
+	client        *rpc.Client
+	serviceMethod string
+	args          interface{}
+	reply         interface{}
+
+	mutex   sync.Mutex
+	started bool
+	done    bool
+	err     error
+}
+
+func (c *call) Run() (interface{}, error) {
+	c.mutex.Lock()
+	c.started = true
+	c.mutex.Unlock()
+
+	reply := reflect.New(reflect.TypeOf(c.reply).Elem()).Interface()
+	err := c.client.Call(c.serviceMethod, c.args, reply)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.done = true
+	c.err = err
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// callError returns the error of the finished call
+func (c *call) callError() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+// runError returns the error to pass to the fallback
+func (c *call) runError() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.started {
+		return goHystrix.ErrCircuitOpen
+	}
+	if !c.done {
+		return fmt.Errorf("%s: timeout", c.serviceMethod)
+	}
+	return c.err
+}
+
+type callFallback struct {
+	*call
+	fallback FallbackFunc
+}
+
+func (c *callFallback) Fallback() (interface{}, error) {
+	return nil, c.fallback(c.serviceMethod, c.args, c.reply, c.runError())
+}
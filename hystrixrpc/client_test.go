@@ -0,0 +1,122 @@
+package hystrixrpc
+
+import (
+	"errors"
+	"github.com/dahernan/goHystrix"
+	"github.com/dahernan/goHystrix/internal/hystrixtest"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Divide(args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (t *Arith) Slow(args *Args, reply *int) error {
+	time.Sleep(100 * time.Millisecond)
+	*reply = 42
+	return nil
+}
+
+func optionsForTest() *goHystrix.CommandOptions {
+	return hystrixtest.Options(20 * time.Millisecond)
+}
+
+func newClient() *Client {
+	server := rpc.NewServer()
+	server.Register(new(Arith))
+	serverConn, clientConn := net.Pipe()
+	go server.ServeConn(serverConn)
+
+	client := NewClient(rpc.NewClient(clientConn), "arith")
+	client.Options = optionsForTest()
+	return client
+}
+
+func TestClient(t *testing.T) {
+	Convey("Client runs every call as a command", t, func() {
+		goHystrix.CircuitsReset()
+		client := newClient()
+		defer client.Close()
+
+		Convey("Call returns the reply", func() {
+			var reply int
+			So(client.Call("Arith.Multiply", &Args{7, 8}, &reply), ShouldBeNil)
+			So(reply, ShouldEqual, 56)
+
+			circuit, ok := goHystrix.Circuits().Get("arith", "Arith.Multiply")
+			So(ok, ShouldBeTrue)
+			So(circuit.Metric().HealthCounts().Success, ShouldEqual, 1)
+		})
+
+		Convey("Hedge in the options is ignored", func() {
+			client.Options.Hedge = goHystrix.HedgeOptions{Delay: time.Millisecond, MaxHedges: 1}
+
+			var reply int
+			So(client.Call("Arith.Multiply", &Args{7, 8}, &reply), ShouldBeNil)
+			So(reply, ShouldEqual, 56)
+		})
+
+		Convey("Go returns the reply asynchronously", func() {
+			var reply int
+			call := <-client.Go("Arith.Multiply", &Args{2, 3}, &reply, nil).Done
+			So(call.Error, ShouldBeNil)
+			So(reply, ShouldEqual, 6)
+		})
+
+		Convey("Errors of the server are failures and open the circuit", func() {
+			var reply int
+			for i := 0; i < 3; i++ {
+				err := client.Call("Arith.Divide", &Args{1, 0}, &reply)
+				So(err, ShouldHaveSameTypeAs, rpc.ServerError(""))
+				So(err.Error(), ShouldEqual, "divide by zero")
+			}
+
+			err := client.Call("Arith.Divide", &Args{1, 1}, &reply)
+			So(err, ShouldEqual, goHystrix.ErrCircuitOpen)
+			So(reply, ShouldEqual, 0)
+		})
+
+		Convey("Timeouts don't write the reply", func() {
+			var reply int
+			err := client.Call("Arith.Slow", &Args{}, &reply)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Timeout")
+
+			time.Sleep(150 * time.Millisecond)
+			So(reply, ShouldEqual, 0)
+
+			circuit, _ := goHystrix.Circuits().Get("arith", "Arith.Slow")
+			So(circuit.Metric().HealthCounts().Timeouts, ShouldEqual, 1)
+		})
+
+		Convey("Fallback fills the reply", func() {
+			client.Fallback = func(serviceMethod string, args interface{}, reply interface{}, err error) error {
+				*(reply.(*int)) = -1
+				return nil
+			}
+
+			var reply int
+			So(client.Call("Arith.Slow", &Args{}, &reply), ShouldBeNil)
+			So(reply, ShouldEqual, -1)
+		})
+	})
+}
@@ -0,0 +1,345 @@
+package hystrixsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/dahernan/goHystrix"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	OpExec    = "exec"
+	OpQuery   = "query"
+	OpPrepare = "prepare"
+	OpBegin   = "begin"
+)
+
+// Driver is a database/sql driver that runs Exec, Query, Prepare and Begin of the wrapped driver
+// as goHystrix commands, the group of the command is the DSN without the credentials and the name is the operation.
+// The context of every call is cancelled when the command times out, the call returns context.DeadlineExceeded
+// once the wrapped driver has returned, and the connection is discarded if the call was interrupted.
+//
+//	sql.Register("hystrix-postgres", &hystrixsql.Driver{Driver: &pq.Driver{}})
+//	db, err := sql.Open("hystrix-postgres", dsn)
+type Driver struct {
+	Driver driver.Driver
+	// Group maps the DSN to the group of the commands, the host and database of the DSN if nil
+	Group func(dsn string) string
+	// Options for the commands, goHystrix.CommandOptionsFor(group, name) if nil, Hedge is ignored
+	Options *goHystrix.CommandOptions
+}
+
+// Register registers the wrapped driver with the default values in database/sql
+func Register(name string, d driver.Driver) {
+	sql.Register(name, &Driver{Driver: d})
+}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	group := dsnGroup(dsn)
+	if d.Group != nil {
+		group = d.Group(dsn)
+	}
+	return &conn{Conn: c, driver: d, group: group}, nil
+}
+
+// dsnGroup returns the DSN without the user, the password and the parameters, so they are not in the metrics:
+// scheme://host/db for URLs, the host, port and dbname of key=value DSNs, and protocol(address)/db otherwise
+func dsnGroup(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host + u.Path
+	}
+	fields := strings.Fields(dsn)
+	if len(fields) > 0 {
+		if key, _, ok := strings.Cut(fields[0], "="); ok && !strings.ContainsAny(key, ":@/(") {
+			var kept []string
+			for _, field := range fields {
+				key, _, _ := strings.Cut(field, "=")
+				switch key {
+				case "host", "port", "dbname":
+					kept = append(kept, field)
+				}
+			}
+			return strings.Join(kept, " ")
+		}
+	}
+	if i := strings.LastIndex(dsn, "@"); i >= 0 {
+		dsn = dsn[i+1:]
+	}
+	if i := strings.Index(dsn, "?"); i >= 0 {
+		dsn = dsn[:i]
+	}
+	return dsn
+}
+
+type commandFunc func() (interface{}, error)
+
+func (f commandFunc) Run() (interface{}, error) {
+	return f()
+}
+
+// execute runs the operation as a command, the context passed to run is cancelled when
+// the command fails or times out, otherwise the caller owns the cancel function.
+// It returns the error of run when there is one, so database/sql sees errors like driver.ErrBadConn.
+// The connection is never used by two calls: when the command times out it waits for run to return,
+// and marks the connection as bad if run was interrupted.
+func (c *conn) execute(ctx context.Context, op string, run func(ctx context.Context) (interface{}, error)) (interface{}, context.CancelFunc, error) {
+	d := c.driver
+	options := goHystrix.CommandOptionsFor(c.group, op)
+	if d.Options != nil {
+		options = *d.Options
+	}
+	// the queries of a connection can't run concurrently, they are never hedged
+	options.Hedge = goHystrix.HedgeOptions{}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		mutex     sync.Mutex
+		running   bool
+		abandoned bool
+		value     interface{}
+		runErr    error
+	)
+	done := make(chan struct{})
+	command, err := goHystrix.NewCommandWithOptions(op, c.group, commandFunc(func() (interface{}, error) {
+		mutex.Lock()
+		if abandoned {
+			mutex.Unlock()
+			return nil, context.Canceled
+		}
+		running = true
+		mutex.Unlock()
+
+		defer close(done)
+		v, err := run(ctx)
+		if err == driver.ErrSkip {
+			// not supported by the driver, database/sql uses another way
+			return skipped{}, nil
+		}
+		value, runErr = v, err
+		return v, err
+	}), options)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	result, err := command.Execute()
+	if err != nil {
+		cancel()
+		mutex.Lock()
+		abandoned = true
+		started := running
+		mutex.Unlock()
+		if !started {
+			// rejected by the circuit, run never touches the connection
+			return nil, nil, err
+		}
+		<-done
+		interrupted := errors.Is(runErr, context.Canceled) || errors.Is(runErr, context.DeadlineExceeded)
+		if interrupted {
+			// the state of the connection is unknown
+			atomic.StoreInt32(&c.bad, 1)
+		}
+		switch {
+		case parent.Err() != nil:
+			release(value)
+			return nil, nil, parent.Err()
+		case interrupted:
+			return nil, nil, context.DeadlineExceeded
+		case runErr != nil:
+			return nil, nil, runErr
+		}
+		// finished after the timeout
+		release(value)
+		return nil, nil, context.DeadlineExceeded
+	}
+	if _, ok := result.(skipped); ok {
+		cancel()
+		return nil, nil, driver.ErrSkip
+	}
+	return result, cancel, nil
+}
+
+// release frees the value of a call that is not returned to database/sql
+func release(value interface{}) {
+	switch v := value.(type) {
+	case driver.Tx:
+		v.Rollback()
+	case io.Closer:
+		v.Close()
+	}
+}
+
+type skipped struct{}
+
+type conn struct {
+	driver.Conn
+	driver *Driver
+	group  string
+	// bad is 1 when a call was interrupted by a timeout, database/sql discards the connection
+	bad int32
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	value, cancel, err := c.execute(ctx, OpPrepare, func(ctx context.Context) (interface{}, error) {
+		if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+			return p.PrepareContext(ctx, query)
+		}
+		return c.Conn.Prepare(query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+	return &stmt{Stmt: value.(driver.Stmt), conn: c}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	value, cancel, err := c.execute(ctx, OpBegin, func(ctx context.Context) (interface{}, error) {
+		if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+			// the transaction lives after the command, it can't use the cancellable context
+			return b.BeginTx(context.WithoutCancel(ctx), opts)
+		}
+		return c.Conn.Begin()
+	})
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+	return value.(driver.Tx), nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	value, cancel, err := c.execute(ctx, OpExec, func(ctx context.Context) (interface{}, error) {
+		return execer.ExecContext(ctx, query, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+	return value.(driver.Result), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	value, cancel, err := c.execute(ctx, OpQuery, func(ctx context.Context) (interface{}, error) {
+		return queryer.QueryContext(ctx, query, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rows{Rows: value.(driver.Rows), cancel: cancel}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if atomic.LoadInt32(&c.bad) == 1 {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid reports if the connection can go back to the pool, not after a call interrupted by a timeout
+func (c *conn) IsValid() bool {
+	if atomic.LoadInt32(&c.bad) == 1 {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	conn *conn
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	value, cancel, err := s.conn.execute(ctx, OpExec, func(ctx context.Context) (interface{}, error) {
+		if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+			return e.ExecContext(ctx, args)
+		}
+		return s.Stmt.Exec(values(args))
+	})
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+	return value.(driver.Result), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	value, cancel, err := s.conn.execute(ctx, OpQuery, func(ctx context.Context) (interface{}, error) {
+		if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return q.QueryContext(ctx, args)
+		}
+		return s.Stmt.Query(values(args))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rows{Rows: value.(driver.Rows), cancel: cancel}, nil
+}
+
+// rows releases the context of the query when they are closed
+type rows struct {
+	driver.Rows
+	cancel context.CancelFunc
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v[i] = arg.Value
+	}
+	return v
+}
@@ -0,0 +1,139 @@
+package goHystrix
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dahernan/goHystrix/statsd"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxExport writes snapshots of the circuits in InfluxDB line protocol, one line per circuit
+// with the measurement "hystrix", the tags group and name, and the counts, the latency percentiles,
+// the effective timeout, the concurrency and the open state as fields:
+//
+//	hystrix,group=db,name=users open=false,total=10i,success=8i,failures=2i,... 1414245245000000000
+//
+// The snapshots are taken in State, the single events are not exported.
+type InfluxExport struct {
+	exportWriter
+	// now is the time of the snapshots
+	now func() time.Time
+}
+
+// influxPacketSize is the max size of a write, so every write fits in a udp packet
+const influxPacketSize = statsd.MTU_PACKET_SIZE
+
+// influxTagReplacer escapes the tag values
+var influxTagReplacer = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// NewInfluxExport writes the line protocol into w
+func NewInfluxExport(w io.Writer) *InfluxExport {
+	return &InfluxExport{
+		exportWriter: exportWriter{w: w, exporter: "influx"},
+		now:          time.Now,
+	}
+}
+
+// UseInflux writes the state of the circuits every dur, for example to the socket listener of Telegraf.
+// The address is "host:port" for udp, or has the protocol like "tcp://host:8094",
+// the connection is dialed when the metrics are sent and dialed again after errors.
+func UseInflux(address string, dur time.Duration) {
+	proto, endpoint := statsd.ParseAddress(address)
+	conn, err := statsd.NewConn(proto, endpoint)
+	if err != nil {
+		logEvent(slog.LevelError, "exporter.failed", "Error setting InfluxDB for publishing the metrics: "+err.Error(), "exporter", "influx", "error", err)
+		logEvent(slog.LevelWarn, "exporter.disabled", "Using NilExport for publishing the metrics", "exporter", "influx")
+		SetExporter(NilExport{})
+		return
+	}
+	SetExporter(NewInfluxExport(conn))
+	PollState(Circuits(), dur)
+}
+
+func (e *InfluxExport) Success(group string, name string, duration time.Duration) {}
+func (e *InfluxExport) Fail(group string, name string)                            {}
+func (e *InfluxExport) Fallback(group string, name string)                        {}
+func (e *InfluxExport) FallbackError(group string, name string)                   {}
+func (e *InfluxExport) Timeout(group string, name string)                         {}
+func (e *InfluxExport) Panic(group string, name string)                           {}
+func (e *InfluxExport) Hedge(group string, name string)                           {}
+func (e *InfluxExport) Throttled(group string, name string)                       {}
+func (e *InfluxExport) Rejected(group string, name string)                        {}
+
+// State writes a line for every circuit, sorted by group and name
+func (e *InfluxExport) State(holder *CircuitHolder) {
+	timestamp := e.now().UnixNano()
+
+	var lines [][]byte
+	for _, circuit := range holder.sortedCircuits() {
+		lines = append(lines, influxLine(circuit, timestamp))
+	}
+	e.writeLines(lines)
+}
+
+// writeLines writes the lines in writes no larger than influxPacketSize (unless a line is larger)
+func (e *InfluxExport) writeLines(lines [][]byte) {
+	var buffer bytes.Buffer
+	flush := func() {
+		if buffer.Len() == 0 {
+			return
+		}
+		e.write(buffer.Bytes())
+		buffer.Reset()
+	}
+	for _, line := range lines {
+		if buffer.Len() > 0 && buffer.Len()+len(line) > influxPacketSize {
+			flush()
+		}
+		buffer.Write(line)
+	}
+	flush()
+}
+
+// influxLine is the line protocol of the circuit, ending with a newline
+func influxLine(circuit *CircuitBreaker, timestamp int64) []byte {
+	open, _ := circuit.IsOpen()
+	counts := circuit.Metric().HealthCounts()
+	stats := circuit.Metric().Stats()
+	limit, inFlight := circuit.ConcurrencyLimit()
+
+	var buffer bytes.Buffer
+	buffer.WriteString("hystrix")
+	fmt.Fprintf(&buffer, ",group=%s,name=%s ", influxTagReplacer.Replace(circuit.group), influxTagReplacer.Replace(circuit.name))
+
+	fmt.Fprintf(&buffer, "open=%t", open)
+	fmt.Fprintf(&buffer, ",total=%di", counts.Total)
+	fmt.Fprintf(&buffer, ",success=%di", counts.Success)
+	fmt.Fprintf(&buffer, ",failures=%di", counts.Failures)
+	fmt.Fprintf(&buffer, ",timeouts=%di", counts.Timeouts)
+	fmt.Fprintf(&buffer, ",fallback=%di", counts.Fallback)
+	fmt.Fprintf(&buffer, ",fallbackErrors=%di", counts.FallbackErrors)
+	fmt.Fprintf(&buffer, ",panics=%di", counts.Panics)
+	fmt.Fprintf(&buffer, ",hedges=%di", counts.Hedges)
+	fmt.Fprintf(&buffer, ",throttled=%di", counts.Throttled)
+	fmt.Fprintf(&buffer, ",rejected=%di", counts.Rejected)
+	fmt.Fprintf(&buffer, ",errorPercentage=%s", formatFloat(counts.ErrorPercentage))
+	// the effective timeout in ms, the adaptive one if it is set
+	fmt.Fprintf(&buffer, ",timeout=%s", formatFloat(float64(circuit.Timeout())/float64(time.Millisecond)))
+	fmt.Fprintf(&buffer, ",concurrencyLimit=%di,inFlight=%di", limit, inFlight)
+
+	if stats.Count() > 0 {
+		// the latencies in ms
+		percentiles := stats.Percentiles([]float64{0.5, 0.9, 0.99})
+		fmt.Fprintf(&buffer, ",p50=%s", formatFloat(percentiles[0]/float64(time.Millisecond)))
+		fmt.Fprintf(&buffer, ",p90=%s", formatFloat(percentiles[1]/float64(time.Millisecond)))
+		fmt.Fprintf(&buffer, ",p99=%s", formatFloat(percentiles[2]/float64(time.Millisecond)))
+		fmt.Fprintf(&buffer, ",mean=%s", formatFloat(stats.Mean()/float64(time.Millisecond)))
+	}
+
+	fmt.Fprintf(&buffer, " %d\n", timestamp)
+	return buffer.Bytes()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,93 @@
+package goHystrix
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxExport(t *testing.T) {
+	Convey("InfluxExport writes the state of the circuits in line protocol", t, func() {
+		CircuitsReset()
+		buffer := &syncBuffer{}
+		export := NewInfluxExport(buffer)
+		export.now = func() time.Time { return time.Unix(1414245245, 0) }
+
+		command := MustNewCommand("influxCmd", "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+		errCommand := MustNewCommand("errCmd", "testGroup", &StringCommand{state: "error"}, CommandOptionsForTest())
+		errCommand.Execute()
+		circuit, _ := Circuits().Get("testGroup", "influxCmd")
+		circuit.Metric().HealthCounts() // the success is in the sample
+		circuit.Metric().Stats().Clear()
+		circuit.Metric().Stats().Update(int64(12500 * time.Microsecond))
+
+		export.State(Circuits())
+
+		So(buffer.String(), ShouldEqual, strings.Join([]string{
+			"hystrix,group=testGroup,name=errCmd open=false,total=1i,success=0i,failures=1i,timeouts=0i," +
+				"fallback=1i,fallbackErrors=0i,panics=0i,hedges=0i,throttled=0i,rejected=0i,errorPercentage=100," +
+				"timeout=3,concurrencyLimit=0i,inFlight=0i 1414245245000000000",
+			"hystrix,group=testGroup,name=influxCmd open=false,total=1i,success=1i,failures=0i,timeouts=0i," +
+				"fallback=0i,fallbackErrors=0i,panics=0i,hedges=0i,throttled=0i,rejected=0i,errorPercentage=0," +
+				"timeout=3,concurrencyLimit=0i,inFlight=0i,p50=12.5,p90=12.5,p99=12.5,mean=12.5 1414245245000000000",
+			"",
+		}, "\n"))
+	})
+
+	Convey("The tags are escaped", t, func() {
+		CircuitsReset()
+		buffer := &syncBuffer{}
+		export := NewInfluxExport(buffer)
+		NewCircuit("my group", "a,b=c", CommandOptionsForTest())
+
+		export.State(Circuits())
+		So(buffer.String(), ShouldStartWith, "hystrix,group=my\\ group,name=a\\,b\\=c open=false,")
+	})
+
+	Convey("The lines are written in packets that fit in udp", t, func() {
+		CircuitsReset()
+		w := &countWriter{}
+		export := NewInfluxExport(w)
+		for i := 0; i < 20; i++ {
+			NewCircuit("testGroup", strings.Repeat("x", i+1), CommandOptionsForTest())
+		}
+
+		export.State(Circuits())
+		So(len(w.writes), ShouldBeGreaterThan, 1)
+		for _, n := range w.writes {
+			So(n, ShouldBeLessThanOrEqualTo, influxPacketSize)
+		}
+	})
+
+	Convey("UseInflux sends the snapshots to a udp endpoint", t, func() {
+		CircuitsReset()
+		defer SetExporter(NewNilExport())
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		NewCircuit("testGroup", "udpCmd", CommandOptionsForTest())
+		UseInflux(conn.LocalAddr().String(), time.Millisecond)
+
+		buf := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		So(err, ShouldBeNil)
+		So(string(buf[:n]), ShouldStartWith, "hystrix,group=testGroup,name=udpCmd open=false,")
+		So(Circuits().Shutdown(context.Background()), ShouldBeNil)
+	})
+}
+
+type countWriter struct {
+	writes []int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return len(p), nil
+}
@@ -0,0 +1,17 @@
+// Package hystrixtest has the fixtures shared by the tests of the wrappers (hystrixhttp, hystrixsql...)
+package hystrixtest
+
+import (
+	"github.com/dahernan/goHystrix"
+	"time"
+)
+
+// Options are small windows and thresholds, so the tests open the circuits with a few calls
+func Options(timeout time.Duration) *goHystrix.CommandOptions {
+	return &goHystrix.CommandOptions{
+		ErrorsThreshold:        50.0,
+		MinimumNumberOfRequest: 3,
+		NumberOfSecondsToStore: 5,
+		Timeout:                timeout,
+	}
+}
@@ -2,6 +2,7 @@ package goHystrix
 
 import (
 	"github.com/dahernan/goHystrix/sample"
+	"sync"
 	"time"
 )
 
@@ -14,13 +15,20 @@ type Metric struct {
 	group string
 
 	successChan       chan time.Duration
-	failuresChan      chan struct{}
+	failuresChan      chan error
 	fallbackChan      chan struct{}
 	fallbackErrorChan chan struct{}
-	timeoutsChan      chan struct{}
+	timeoutsChan      chan error
 	panicChan         chan struct{}
+	hedgeChan         chan struct{}
+	throttledChan     chan struct{}
+	rejectedChan      chan error
+	statusChan        chan int
 	countersChan      chan struct{}
 	countersOutChan   chan HealthCounts
+	done              chan struct{}
+	stopped           chan struct{}
+	stopOnce          sync.Once
 
 	buckets int
 	window  time.Duration
@@ -33,11 +41,30 @@ type Metric struct {
 	lastTimeout time.Time
 }
 
+// NewMetric stores 20 seconds of counts, and of latencies in a rolling histogram
 func NewMetric(group string, name string) *Metric {
-	return NewMetricWithParams(group, name, 20, 50)
+	options := CommandOptions{NumberOfSecondsToStore: 20, LatencySample: SampleRolling}
+	return NewMetricWithSample(group, name, options.NumberOfSecondsToStore, options.latencySample())
 }
 
+// NewMetricWithParams stores the latencies in an exponentially decaying sample of sampleSize,
+// the values <= 0 take the defaults of CommandOptionsDefaults()
 func NewMetricWithParams(group string, name string, numberOfSecondsToStore int, sampleSize int) *Metric {
+	if sampleSize <= 0 {
+		sampleSize = CommandOptionsDefaults().NumberOfSamplesToStore
+	}
+	return NewMetricWithSample(group, name, numberOfSecondsToStore, sample.NewExpDecaySample(sampleSize, alpha))
+}
+
+// NewMetricWithSample records the latencies in the sample, numberOfSecondsToStore <= 0 takes the default
+// of CommandOptionsDefaults() and a nil sample the rolling histogram
+func NewMetricWithSample(group string, name string, numberOfSecondsToStore int, latencies sample.Sample) *Metric {
+	if numberOfSecondsToStore <= 0 {
+		numberOfSecondsToStore = CommandOptionsDefaults().NumberOfSecondsToStore
+	}
+	if latencies == nil {
+		latencies = CommandOptions{NumberOfSecondsToStore: numberOfSecondsToStore, LatencySample: SampleRolling}.latencySample()
+	}
 	m := &Metric{}
 	m.name = name
 	m.group = group
@@ -45,16 +72,22 @@ func NewMetricWithParams(group string, name string, numberOfSecondsToStore int,
 	m.window = time.Duration(numberOfSecondsToStore) * time.Second
 	m.values = make([]HealthCountsBucket, m.buckets, m.buckets)
 
-	m.sample = sample.NewExpDecaySample(sampleSize, alpha)
+	m.sample = latencies
 
 	m.successChan = make(chan time.Duration)
-	m.failuresChan = make(chan struct{})
+	m.failuresChan = make(chan error)
 	m.fallbackChan = make(chan struct{})
 	m.fallbackErrorChan = make(chan struct{})
-	m.timeoutsChan = make(chan struct{})
+	m.timeoutsChan = make(chan error)
 	m.panicChan = make(chan struct{})
+	m.hedgeChan = make(chan struct{})
+	m.throttledChan = make(chan struct{})
+	m.rejectedChan = make(chan error)
+	m.statusChan = make(chan int)
 	m.countersChan = make(chan struct{})
 	m.countersOutChan = make(chan HealthCounts)
+	m.done = make(chan struct{})
+	m.stopped = make(chan struct{})
 
 	go m.run()
 	return m
@@ -68,7 +101,12 @@ type HealthCountsBucket struct {
 	FallbackErrors int64
 	Timeouts       int64
 	Panics         int64
-	lastWrite      time.Time
+	Hedges         int64
+	Throttled      int64
+	Rejected       int64
+	// StatusClasses counts the HTTP responses by class, StatusClasses[2] are the 2xx
+	StatusClasses [6]int64
+	lastWrite     time.Time
 }
 
 type HealthCounts struct {
@@ -84,23 +122,38 @@ func (c *HealthCountsBucket) Reset() {
 	c.FallbackErrors = 0
 	c.Timeouts = 0
 	c.Panics = 0
+	c.Hedges = 0
+	c.Throttled = 0
+	c.Rejected = 0
+	c.StatusClasses = [6]int64{}
 }
 
 func (m *Metric) run() {
+	defer close(m.stopped)
 	for {
 		select {
+		case <-m.done:
+			return
 		case duration := <-m.successChan:
 			m.doSuccess(duration)
-		case <-m.failuresChan:
-			m.doFail()
-		case <-m.timeoutsChan:
-			m.doTimeout()
+		case err := <-m.failuresChan:
+			m.doFail(err)
+		case err := <-m.timeoutsChan:
+			m.doTimeout(err)
 		case <-m.fallbackChan:
 			m.doFallback()
 		case <-m.fallbackErrorChan:
 			m.doFallbackError()
 		case <-m.panicChan:
 			m.doPanic()
+		case <-m.hedgeChan:
+			m.doHedge()
+		case <-m.throttledChan:
+			m.doThrottled()
+		case err := <-m.rejectedChan:
+			m.doRejected(err)
+		case code := <-m.statusChan:
+			m.doStatus(code)
 		case <-m.countersChan:
 			m.countersOutChan <- m.doHealthCounts()
 			//case <-time.After(2 * time.Second):
@@ -126,18 +179,15 @@ func (m *Metric) bucket() *HealthCountsBucket {
 func (m *Metric) doSuccess(duration time.Duration) {
 	m.bucket().Success++
 	m.lastSuccess = time.Now()
-
-	go func(d time.Duration) {
-		m.sample.Update(int64(d))
-	}(duration)
+	m.sample.Update(int64(duration))
 
 	Exporter().Success(m.group, m.name, duration)
 }
 
-func (m *Metric) doFail() {
+func (m *Metric) doFail(err error) {
 	m.bucket().Failures++
 	m.lastFailure = time.Now()
-	Exporter().Fail(m.group, m.name)
+	exportFail(Exporter(), m.group, m.name, err)
 }
 
 func (m *Metric) doFallback() {
@@ -145,13 +195,13 @@ func (m *Metric) doFallback() {
 	Exporter().Fallback(m.group, m.name)
 }
 
-func (m *Metric) doTimeout() {
+func (m *Metric) doTimeout(err error) {
 	m.bucket().Timeouts++
 	m.bucket().Failures++
 	now := time.Now()
 	m.lastFailure = now
 	m.lastTimeout = now
-	Exporter().Timeout(m.group, m.name)
+	exportTimeout(Exporter(), m.group, m.name, err)
 }
 
 func (m *Metric) doFallbackError() {
@@ -164,6 +214,28 @@ func (m *Metric) doPanic() {
 	Exporter().Panic(m.group, m.name)
 }
 
+func (m *Metric) doHedge() {
+	m.bucket().Hedges++
+	Exporter().Hedge(m.group, m.name)
+}
+
+func (m *Metric) doThrottled() {
+	m.bucket().Throttled++
+	Exporter().Throttled(m.group, m.name)
+}
+
+func (m *Metric) doRejected(err error) {
+	m.bucket().Rejected++
+	exportRejected(Exporter(), m.group, m.name, err)
+}
+
+func (m *Metric) doStatus(code int) {
+	class := code / 100
+	if class > 0 && class < len(m.bucket().StatusClasses) {
+		m.bucket().StatusClasses[class]++
+	}
+}
+
 func (m *Metric) doHealthCounts() (counters HealthCounts) {
 	now := time.Now()
 	for _, value := range m.values {
@@ -174,6 +246,12 @@ func (m *Metric) doHealthCounts() (counters HealthCounts) {
 			counters.FallbackErrors += value.FallbackErrors
 			counters.Timeouts += value.Timeouts
 			counters.Panics += value.Panics
+			counters.Hedges += value.Hedges
+			counters.Throttled += value.Throttled
+			counters.Rejected += value.Rejected
+			for class, n := range value.StatusClasses {
+				counters.StatusClasses[class] += n
+			}
 		}
 	}
 	counters.Total = counters.Success + counters.Failures
@@ -186,32 +264,102 @@ func (m *Metric) doHealthCounts() (counters HealthCounts) {
 }
 
 func (m *Metric) HealthCounts() HealthCounts {
-	m.countersChan <- struct{}{}
-	return <-m.countersOutChan
+	select {
+	case m.countersChan <- struct{}{}:
+		return <-m.countersOutChan
+	case <-m.stopped:
+		// nothing writes the buckets anymore
+		return m.doHealthCounts()
+	}
+}
+
+// Stop stops the goroutine of the metric, the events after Stop are discarded
+func (m *Metric) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+	<-m.stopped
+}
+
+// send sends the event to the goroutine of the metric, unless it is stopped
+func (m *Metric) send(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	case <-m.done:
+	}
 }
 
 func (m *Metric) Success(duration time.Duration) {
-	m.successChan <- duration
+	select {
+	case m.successChan <- duration:
+	case <-m.done:
+	}
+}
+
+// sendError sends the event with its error to the goroutine of the metric, unless it is stopped
+func (m *Metric) sendError(c chan error, err error) {
+	select {
+	case c <- err:
+	case <-m.done:
+	}
 }
 
 func (m *Metric) Fail() {
-	m.failuresChan <- struct{}{}
+	m.sendError(m.failuresChan, nil)
+}
+
+// FailWithError counts a failure, the exporters that implement ErrorExport get the error
+func (m *Metric) FailWithError(err error) {
+	m.sendError(m.failuresChan, err)
 }
 
 func (m *Metric) Fallback() {
-	m.fallbackChan <- struct{}{}
+	m.send(m.fallbackChan)
 }
 
 func (m *Metric) FallbackError() {
-	m.fallbackErrorChan <- struct{}{}
+	m.send(m.fallbackErrorChan)
 }
 
 func (m *Metric) Timeout() {
-	m.timeoutsChan <- struct{}{}
+	m.sendError(m.timeoutsChan, nil)
+}
+
+// TimeoutWithError counts a timeout, the exporters that implement ErrorExport get the error
+func (m *Metric) TimeoutWithError(err error) {
+	m.sendError(m.timeoutsChan, err)
 }
 
 func (m *Metric) Panic() {
-	m.panicChan <- struct{}{}
+	m.send(m.panicChan)
+}
+
+// Hedge counts a hedged attempt, it is not part of the Total
+func (m *Metric) Hedge() {
+	m.send(m.hedgeChan)
+}
+
+// Throttled counts a call rejected by the rate limit, it is not part of the Total
+func (m *Metric) Throttled() {
+	m.send(m.throttledChan)
+}
+
+// Rejected counts a call rejected by the concurrency limit, it is not part of the Total
+func (m *Metric) Rejected() {
+	m.sendError(m.rejectedChan, nil)
+}
+
+// RejectedWithError counts a rejected call, the exporters that implement ErrorExport get the error
+func (m *Metric) RejectedWithError(err error) {
+	m.sendError(m.rejectedChan, err)
+}
+
+// Status counts a HTTP response status code in its class
+func (m *Metric) Status(code int) {
+	select {
+	case m.statusChan <- code:
+	case <-m.done:
+	}
 }
 
 func (m *Metric) Stats() sample.Sample {
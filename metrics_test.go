@@ -154,12 +154,28 @@ func TestMetricsKeepMessuaresSample(t *testing.T) {
 		metric.Success(5)
 		metric.Success(8)
 
+		metric.HealthCounts() // the successes are in the sample
+
 		So(metric.Stats().Max(), ShouldEqual, 9)
 		So(metric.Stats().Min(), ShouldEqual, 1)
-		// flacky because is calculanting async
-		//So(metric.Stats().Mean(), ShouldEqual, 5)
-		//So(metric.Stats().Count(), ShouldEqual, 6)
-		//So(metric.Stats().Variance(), ShouldEqual, 8.333333333333334)
+		So(metric.Stats().Mean(), ShouldEqual, 5)
+		So(metric.Stats().Count(), ShouldEqual, 6)
+		So(metric.Stats().Variance(), ShouldEqual, 8.333333333333334)
+
+	})
+}
 
+func TestMetricsInvalidParams(t *testing.T) {
+	Convey("Metric takes the defaults for the values <= 0", t, func() {
+		metric := NewMetricWithParams("group", "name", 0, 0)
+		metric.Fail()
+		metric.Success(1)
+		So(metric.HealthCounts().Total, ShouldEqual, 2)
+		So(metric.buckets, ShouldEqual, CommandOptionsDefaults().NumberOfSecondsToStore)
+
+		metric = NewMetricWithSample("group", "name", -1, nil)
+		metric.Fail()
+		So(metric.HealthCounts().Failures, ShouldEqual, 1)
+		So(metric.Stats(), ShouldNotBeNil)
 	})
 }
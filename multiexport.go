@@ -0,0 +1,106 @@
+package goHystrix
+
+import (
+	"io"
+	"time"
+)
+
+// MultiExport sends the metrics to all the exporters, like statsd and logs at once
+//
+//	goHystrix.SetExporter(goHystrix.NewMultiExport(statsdExport, logExport))
+type MultiExport []MetricExport
+
+func NewMultiExport(exports ...MetricExport) MetricExport {
+	return MultiExport(exports)
+}
+
+func (m MultiExport) Success(group string, name string, duration time.Duration) {
+	for _, e := range m {
+		e.Success(group, name, duration)
+	}
+}
+
+func (m MultiExport) Fail(group string, name string) {
+	for _, e := range m {
+		e.Fail(group, name)
+	}
+}
+
+func (m MultiExport) Fallback(group string, name string) {
+	for _, e := range m {
+		e.Fallback(group, name)
+	}
+}
+
+func (m MultiExport) FallbackError(group string, name string) {
+	for _, e := range m {
+		e.FallbackError(group, name)
+	}
+}
+
+func (m MultiExport) Timeout(group string, name string) {
+	for _, e := range m {
+		e.Timeout(group, name)
+	}
+}
+
+func (m MultiExport) Panic(group string, name string) {
+	for _, e := range m {
+		e.Panic(group, name)
+	}
+}
+
+func (m MultiExport) Hedge(group string, name string) {
+	for _, e := range m {
+		e.Hedge(group, name)
+	}
+}
+
+func (m MultiExport) Throttled(group string, name string) {
+	for _, e := range m {
+		e.Throttled(group, name)
+	}
+}
+
+func (m MultiExport) Rejected(group string, name string) {
+	for _, e := range m {
+		e.Rejected(group, name)
+	}
+}
+
+func (m MultiExport) FailWithError(group string, name string, err error) {
+	for _, e := range m {
+		exportFail(e, group, name, err)
+	}
+}
+
+func (m MultiExport) TimeoutWithError(group string, name string, err error) {
+	for _, e := range m {
+		exportTimeout(e, group, name, err)
+	}
+}
+
+func (m MultiExport) RejectedWithError(group string, name string, err error) {
+	for _, e := range m {
+		exportRejected(e, group, name, err)
+	}
+}
+
+func (m MultiExport) State(circuits *CircuitHolder) {
+	for _, e := range m {
+		e.State(circuits)
+	}
+}
+
+// Close closes all the exporters that are io.Closer, it returns the first error
+func (m MultiExport) Close() error {
+	var err error
+	for _, e := range m {
+		if closer, ok := e.(io.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
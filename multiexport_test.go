@@ -0,0 +1,29 @@
+package goHystrix
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestMultiExport(t *testing.T) {
+	Convey("MultiExport sends the metrics to all the exporters", t, func() {
+		first := newRecordExport()
+		second := newRecordExport()
+		multi := NewMultiExport(first, second, NewNilExport())
+
+		multi.Success("group", "name", time.Millisecond)
+		multi.Fail("group", "name")
+		multi.Rejected("group", "name")
+
+		expected := []string{"success group.name", "fail group.name", "rejected group.name"}
+		So(first.Events(), ShouldResemble, expected)
+		So(second.Events(), ShouldResemble, expected)
+
+		Convey("Close closes all the exporters", func() {
+			So(multi.(MultiExport).Close(), ShouldBeNil)
+			So(first.closed, ShouldBeTrue)
+			So(second.closed, ShouldBeTrue)
+		})
+	})
+}
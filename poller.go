@@ -0,0 +1,50 @@
+package goHystrix
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// pollerDone stops the poller of the state, nil if there is none
+	pollerDone  chan struct{}
+	pollerMutex sync.Mutex
+)
+
+// PollState calls State of the exporter of SetExporter every interval with the circuits of the holder
+// (Circuits() if nil), so every exporter, also the ones in a MultiExport, gets the snapshots of the circuits.
+// There is one poller, PollState replaces the previous one and an interval <= 0 stops it.
+// CircuitHolder.Shutdown stops it too.
+//
+//	goHystrix.SetExporter(goHystrix.NewMultiExport(statsdExport, influxExport))
+//	goHystrix.PollState(goHystrix.Circuits(), 10*time.Second)
+func PollState(holder *CircuitHolder, interval time.Duration) {
+	pollerMutex.Lock()
+	defer pollerMutex.Unlock()
+	if pollerDone != nil {
+		close(pollerDone)
+		pollerDone = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	pollerDone = make(chan struct{})
+	go pollState(holder, interval, pollerDone)
+}
+
+func pollState(holder *CircuitHolder, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h := holder
+			if h == nil {
+				h = Circuits()
+			}
+			Exporter().State(h)
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package goHystrix
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPollState(t *testing.T) {
+	Convey("PollState sends the state to all the exporters of a MultiExport", t, func() {
+		CircuitsReset()
+		before := runtime.NumGoroutine()
+		first := newRecordExport()
+		second := newRecordExport()
+		SetExporter(NewMultiExport(first, second))
+		defer SetExporter(NewNilExport())
+
+		PollState(Circuits(), time.Millisecond)
+		defer PollState(nil, 0)
+		for i := 0; i < 100 && (len(first.Events()) == 0 || len(second.Events()) == 0); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		So(first.Events(), ShouldContain, "state .")
+		So(second.Events(), ShouldContain, "state .")
+
+		Convey("There is one poller, an interval <= 0 stops it", func() {
+			PollState(Circuits(), time.Millisecond)
+			PollState(Circuits(), time.Millisecond)
+			PollState(nil, 0)
+			So(waitGoroutines(before+1), ShouldBeLessThanOrEqualTo, before+1)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package goHystrix
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter, it refills Rate tokens per second up to Burst tokens
+type tokenBucket struct {
+	rate    float64
+	burst   float64
+	maxWait time.Duration
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(options RateLimitOptions) *tokenBucket {
+	burst := float64(options.Burst)
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:    options.Rate,
+		burst:   burst,
+		maxWait: options.MaxWait,
+		tokens:  burst,
+		last:    time.Now(),
+	}
+}
+
+// reserve takes a token, it returns how long the call has to wait for it,
+// or false if the wait is longer than maxWait (and then no token is taken)
+func (b *tokenBucket) reserve(now time.Time) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > b.maxWait {
+		return 0, false
+	}
+	// the token is reserved in advance, the next calls wait longer
+	b.tokens--
+	return wait, true
+}
@@ -0,0 +1,114 @@
+package goHystrix
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	Convey("Token bucket refills Rate tokens per second up to Burst", t, func() {
+		bucket := newTokenBucket(RateLimitOptions{Rate: 10, Burst: 2})
+		now := bucket.last
+
+		_, ok := bucket.reserve(now)
+		So(ok, ShouldBeTrue)
+		_, ok = bucket.reserve(now)
+		So(ok, ShouldBeTrue)
+		_, ok = bucket.reserve(now)
+		So(ok, ShouldBeFalse)
+
+		// 100ms is one token
+		_, ok = bucket.reserve(now.Add(100 * time.Millisecond))
+		So(ok, ShouldBeTrue)
+		_, ok = bucket.reserve(now.Add(100 * time.Millisecond))
+		So(ok, ShouldBeFalse)
+
+		// never more than Burst
+		now = now.Add(10 * time.Second)
+		for i := 0; i < 2; i++ {
+			_, ok = bucket.reserve(now)
+			So(ok, ShouldBeTrue)
+		}
+		_, ok = bucket.reserve(now)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("With MaxWait the calls wait for their turn", t, func() {
+		bucket := newTokenBucket(RateLimitOptions{Rate: 10, MaxWait: 250 * time.Millisecond})
+		now := bucket.last
+
+		wait, ok := bucket.reserve(now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 0)
+
+		wait, ok = bucket.reserve(now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 100*time.Millisecond)
+
+		wait, ok = bucket.reserve(now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 200*time.Millisecond)
+
+		_, ok = bucket.reserve(now)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestRateLimitedCommand(t *testing.T) {
+	Convey("Throttled calls go to the fallback", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.RateLimit = RateLimitOptions{Rate: 1, Burst: 2}
+		command := MustNewCommand("rateLimitedCmd", "testGroup", &MyStringCommand{"hello"}, options)
+
+		for i := 0; i < 2; i++ {
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "hello")
+		}
+
+		result, err := command.Execute()
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "FALLBACK")
+
+		counts := command.HealthCounts()
+		So(counts.Success, ShouldEqual, 2)
+		So(counts.Throttled, ShouldEqual, 1)
+		So(counts.Fallback, ShouldEqual, 1)
+		So(counts.Total, ShouldEqual, 2)
+	})
+}
+
+func TestThrottledError(t *testing.T) {
+	Convey("Throttled calls without fallback return ErrThrottled", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.RateLimit = RateLimitOptions{Rate: 1, Burst: 1}
+		command := MustNewCommand("throttledCmd", "testGroup", &ResultCommand{"result", nil, false}, options)
+
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+		_, err = command.Execute()
+		So(errors.Is(err, ErrThrottled), ShouldBeTrue)
+	})
+}
+
+func TestQueueDepth(t *testing.T) {
+	Convey("The calls waiting for the rate limit are in the QueueDepth", t, func() {
+		CircuitsReset()
+		options := CommandOptionsForTest()
+		options.RateLimit = RateLimitOptions{Rate: 10, Burst: 1, MaxWait: time.Second}
+		circuit := NewCircuit("testGroup", "queuedCmd", options)
+
+		So(circuit.Allow(), ShouldBeTrue)
+		done := make(chan bool)
+		go func() { done <- circuit.Allow() }()
+
+		time.Sleep(20 * time.Millisecond)
+		So(circuit.QueueDepth(), ShouldEqual, 1)
+		So(<-done, ShouldBeTrue)
+		So(circuit.QueueDepth(), ShouldEqual, 0)
+	})
+}
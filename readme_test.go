@@ -51,7 +51,7 @@ func TestString(t *testing.T) {
 func TestStringWithOptions(t *testing.T) {
 
 	// Sync execution
-	command := NewCommandWithOptions("stringMessage", "stringGroup", &MyStringCommand{"helloooooooo"}, CommandOptions{
+	command := MustNewCommand("stringMessage", "stringGroup", &MyStringCommand{"helloooooooo"}, CommandOptions{
 		ErrorsThreshold:        60.0,
 		MinimumNumberOfRequest: 3,
 		NumberOfSecondsToStore: 5,
@@ -0,0 +1,300 @@
+package sample
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// HDRHistogram is a High Dynamic Range histogram: it records every value
+// between lowest and highest with a relative error of at most
+// 10^-significantFigures, in a fixed number of counters. Update is constant
+// time and doesn't allocate, so it records all the values instead of a
+// reservoir of them, and the tail percentiles are accurate.
+//
+// The values under lowest are recorded as lowest and the values over highest
+// as highest.
+type HDRHistogram struct {
+	lowest             int64
+	highest            int64
+	significantFigures int
+
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	counts                      []int64
+
+	count int64
+	sum   int64
+	min   int64
+	max   int64
+}
+
+// NewHDRHistogram constructs a histogram for the values between lowest (at
+// least 1) and highest, with significantFigures (1 to 5) of precision.
+func NewHDRHistogram(lowest, highest int64, significantFigures int) *HDRHistogram {
+	if lowest < 1 {
+		lowest = 1
+	}
+	if highest < 2*lowest {
+		highest = 2 * lowest
+	}
+	if significantFigures < 1 {
+		significantFigures = 1
+	}
+	if significantFigures > 5 {
+		significantFigures = 5
+	}
+
+	largestWithSingleUnitResolution := 2 * int64(math.Pow10(significantFigures))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestWithSingleUnitResolution))))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	unitMagnitude := uint(math.Floor(math.Log2(float64(lowest))))
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	// buckets of double range until highest fits
+	smallestUntrackable := subBucketCount << unitMagnitude
+	bucketCount := 1
+	for smallestUntrackable <= highest {
+		if smallestUntrackable > math.MaxInt64/2 {
+			bucketCount++
+			break
+		}
+		smallestUntrackable <<= 1
+		bucketCount++
+	}
+
+	h := &HDRHistogram{
+		lowest:                      lowest,
+		highest:                     highest,
+		significantFigures:          significantFigures,
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               (subBucketCount - 1) << unitMagnitude,
+		counts:                      make([]int64, (bucketCount+1)*int(subBucketCount/2)),
+	}
+	h.Clear()
+	return h
+}
+
+// index returns the position of the counter of the value.
+func (h *HDRHistogram) index(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	bucket := pow2Ceiling - int(h.unitMagnitude) - int(h.subBucketHalfCountMagnitude+1)
+	subBucket := v >> (uint(bucket) + h.unitMagnitude)
+	return (bucket+1)<<h.subBucketHalfCountMagnitude + int(subBucket-h.subBucketHalfCount)
+}
+
+// valueRange returns the lowest value of the counter and the size of its range.
+func (h *HDRHistogram) valueRange(index int) (int64, int64) {
+	bucket := (index >> h.subBucketHalfCountMagnitude) - 1
+	subBucket := int64(index)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= h.subBucketHalfCount
+		bucket = 0
+	}
+	shift := uint(bucket) + h.unitMagnitude
+	return subBucket << shift, int64(1) << shift
+}
+
+// value is the value that represents the counter, the middle of its range
+// clamped by the min and the max recorded.
+func (h *HDRHistogram) value(index int) int64 {
+	lowest, size := h.valueRange(index)
+	v := lowest + size/2
+	if min := atomic.LoadInt64(&h.min); v < min {
+		v = min
+	}
+	if max := atomic.LoadInt64(&h.max); v > max {
+		v = max
+	}
+	return v
+}
+
+// Clear clears all samples.
+func (h *HDRHistogram) Clear() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.count, 0)
+	atomic.StoreInt64(&h.sum, 0)
+	atomic.StoreInt64(&h.min, math.MaxInt64)
+	atomic.StoreInt64(&h.max, 0)
+}
+
+// Count returns the number of samples recorded.
+func (h *HDRHistogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Max returns the maximum value recorded.
+func (h *HDRHistogram) Max() int64 {
+	if h.Count() == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.max)
+}
+
+// Mean returns the mean of the values recorded.
+func (h *HDRHistogram) Mean() float64 {
+	count := h.Count()
+	if count == 0 {
+		return 0.0
+	}
+	return float64(atomic.LoadInt64(&h.sum)) / float64(count)
+}
+
+// Min returns the minimum value recorded.
+func (h *HDRHistogram) Min() int64 {
+	if h.Count() == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.min)
+}
+
+// Percentile returns an arbitrary percentile (0.99 is the 99th) of the values
+// recorded.
+func (h *HDRHistogram) Percentile(p float64) float64 {
+	return h.Percentiles([]float64{p})[0]
+}
+
+// Percentiles returns a slice of arbitrary percentiles of the values recorded.
+func (h *HDRHistogram) Percentiles(ps []float64) []float64 {
+	scores := make([]float64, len(ps))
+	total := h.totalCounts()
+	if total == 0 {
+		return scores
+	}
+	for i, p := range ps {
+		rank := int64(math.Ceil(p * float64(total)))
+		if rank < 1 {
+			rank = 1
+		}
+		var seen int64
+		for index := range h.counts {
+			seen += atomic.LoadInt64(&h.counts[index])
+			if seen >= rank {
+				scores[i] = float64(h.value(index))
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// totalCounts sums the counters, Count can be ahead of them while an Update
+// is running.
+func (h *HDRHistogram) totalCounts() int64 {
+	var total int64
+	for i := range h.counts {
+		total += atomic.LoadInt64(&h.counts[i])
+	}
+	return total
+}
+
+// Size returns the number of values recorded.
+func (h *HDRHistogram) Size() int {
+	return int(h.Count())
+}
+
+// Snapshot returns a read-only copy of the histogram.
+func (h *HDRHistogram) Snapshot() Sample {
+	c := &HDRHistogram{
+		lowest:                      h.lowest,
+		highest:                     h.highest,
+		significantFigures:          h.significantFigures,
+		unitMagnitude:               h.unitMagnitude,
+		subBucketHalfCountMagnitude: h.subBucketHalfCountMagnitude,
+		subBucketHalfCount:          h.subBucketHalfCount,
+		subBucketMask:               h.subBucketMask,
+		counts:                      make([]int64, len(h.counts)),
+		count:                       h.Count(),
+		sum:                         atomic.LoadInt64(&h.sum),
+		min:                         atomic.LoadInt64(&h.min),
+		max:                         atomic.LoadInt64(&h.max),
+	}
+	for i := range h.counts {
+		c.counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return c
+}
+
+// StdDev returns the standard deviation of the values recorded.
+func (h *HDRHistogram) StdDev() float64 {
+	return math.Sqrt(h.Variance())
+}
+
+// Sum returns the sum of the values recorded.
+func (h *HDRHistogram) Sum() int64 {
+	return atomic.LoadInt64(&h.sum)
+}
+
+// Update records a value.
+func (h *HDRHistogram) Update(v int64) {
+	if v < h.lowest {
+		v = h.lowest
+	}
+	if v > h.highest {
+		v = h.highest
+	}
+	atomic.AddInt64(&h.counts[h.index(v)], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		min := atomic.LoadInt64(&h.min)
+		if v >= min || atomic.CompareAndSwapInt64(&h.min, min, v) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+// subtract removes the values of other, a histogram with the same range and
+// precision, from the counters, the count and the sum. The min and the max are
+// not changed.
+func (h *HDRHistogram) subtract(other *HDRHistogram) {
+	for i := range other.counts {
+		if n := atomic.LoadInt64(&other.counts[i]); n > 0 {
+			atomic.AddInt64(&h.counts[i], -n)
+		}
+	}
+	atomic.AddInt64(&h.count, -other.Count())
+	atomic.AddInt64(&h.sum, -atomic.LoadInt64(&other.sum))
+}
+
+// Values returns a value for every counter that is not empty, at the
+// precision of the histogram (not a value for every sample).
+func (h *HDRHistogram) Values() []int64 {
+	values := make([]int64, 0)
+	for i := range h.counts {
+		if atomic.LoadInt64(&h.counts[i]) > 0 {
+			values = append(values, h.value(i))
+		}
+	}
+	return values
+}
+
+// Variance returns the variance of the values recorded, computed with the
+// values of the counters.
+func (h *HDRHistogram) Variance() float64 {
+	total := h.totalCounts()
+	if total == 0 {
+		return 0.0
+	}
+	mean := h.Mean()
+	var sum float64
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n > 0 {
+			d := float64(h.value(i)) - mean
+			sum += d * d * float64(n)
+		}
+	}
+	return sum / float64(total)
+}
@@ -0,0 +1,117 @@
+package sample
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func BenchmarkHDRHistogram(b *testing.B) {
+	benchmarkSample(b, NewHDRHistogram(1000, 60e9, 2))
+}
+
+func TestHDRHistogramPercentiles(t *testing.T) {
+	h := NewHDRHistogram(1, 1e9, 3)
+	r := rand.New(rand.NewSource(1))
+	values := make([]int64, 100000)
+	for i := range values {
+		// long tail, mostly small values with some big ones
+		values[i] = int64(math.Exp(r.Float64()*15)) + 1
+		h.Update(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	if count := h.Count(); count != 100000 {
+		t.Errorf("h.Count(): 100000 != %v\n", count)
+	}
+	if min := h.Min(); min != values[0] {
+		t.Errorf("h.Min(): %v != %v\n", values[0], min)
+	}
+	if max := h.Max(); max != values[len(values)-1] {
+		t.Errorf("h.Max(): %v != %v\n", values[len(values)-1], max)
+	}
+	for _, p := range []float64{0.5, 0.9, 0.99, 0.999, 1} {
+		rank := int(math.Ceil(p*float64(len(values)))) - 1
+		expected := float64(values[rank])
+		actual := h.Percentile(p)
+		if math.Abs(actual-expected)/expected > 0.001 {
+			t.Errorf("h.Percentile(%v): %v != %v\n", p, expected, actual)
+		}
+	}
+}
+
+func TestHDRHistogramPrecision(t *testing.T) {
+	for figures := 1; figures <= 4; figures++ {
+		h := NewHDRHistogram(1, 1e12, figures)
+		maxError := math.Pow10(-figures)
+		for v := int64(1); v < 1e12; v = v*3 + 7 {
+			lowest, size := h.valueRange(h.index(v))
+			if v < lowest || v >= lowest+size {
+				t.Errorf("%v is not in the range [%v, %v)\n", v, lowest, lowest+size)
+			}
+			// a range of 1 is exact
+			if size > 1 && float64(size)/2/float64(v) > maxError {
+				t.Errorf("%v figures: the range of %v is %v\n", figures, v, size)
+			}
+		}
+	}
+}
+
+func TestHDRHistogramClamp(t *testing.T) {
+	h := NewHDRHistogram(1000, 1e6, 2)
+	h.Update(1)
+	h.Update(1e9)
+	if min := h.Min(); min != 1000 {
+		t.Errorf("h.Min(): 1000 != %v\n", min)
+	}
+	if max := h.Max(); max != 1e6 {
+		t.Errorf("h.Max(): 1000000 != %v\n", max)
+	}
+	if p := h.Percentile(1); p != 1e6 {
+		t.Errorf("h.Percentile(1): 1000000 != %v\n", p)
+	}
+}
+
+func TestHDRHistogramUpdateDoesNotAllocate(t *testing.T) {
+	h := NewHDRHistogram(1000, 60e9, 3)
+	v := int64(0)
+	allocs := testing.AllocsPerRun(1000, func() {
+		v += 12345
+		h.Update(v)
+	})
+	if allocs != 0 {
+		t.Errorf("h.Update allocates %v\n", allocs)
+	}
+}
+
+func TestHDRHistogramStatistics(t *testing.T) {
+	h := NewHDRHistogram(1, 1e6, 3)
+	for i := 1; i <= 10000; i++ {
+		h.Update(int64(i))
+	}
+	if sum := h.Sum(); sum != 50005000 {
+		t.Errorf("h.Sum(): 50005000 != %v\n", sum)
+	}
+	if mean := h.Mean(); mean != 5000.5 {
+		t.Errorf("h.Mean(): 5000.5 != %v\n", mean)
+	}
+	if stdDev := h.StdDev(); math.Abs(stdDev-2886.751)/2886.751 > 0.001 {
+		t.Errorf("h.StdDev(): 2886.751 != %v\n", stdDev)
+	}
+
+	snapshot := h.Snapshot()
+	h.Clear()
+	if count := h.Count(); count != 0 {
+		t.Errorf("h.Count(): 0 != %v\n", count)
+	}
+	if p := h.Percentile(0.99); p != 0 {
+		t.Errorf("h.Percentile(0.99): 0 != %v\n", p)
+	}
+	if count := snapshot.Count(); count != 10000 {
+		t.Errorf("snapshot.Count(): 10000 != %v\n", count)
+	}
+	if p := snapshot.Percentile(0.5); math.Abs(p-5000)/5000 > 0.001 {
+		t.Errorf("snapshot.Percentile(0.5): 5000 != %v\n", p)
+	}
+}
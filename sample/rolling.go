@@ -0,0 +1,248 @@
+package sample
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RollingHistogram records the values in a ring of HDR histograms, one per
+// bucket of time, and answers the statistics over the live buckets only, so
+// the old values leave the window when their bucket is rotated (like the
+// health counts of a circuit).
+//
+// The sum of the live buckets is kept in another histogram, so the
+// percentiles cost the same as with a single HDRHistogram.
+type RollingHistogram struct {
+	bucketDuration time.Duration
+	// now is the clock of the buckets, the slots are counted from start
+	// with the monotonic clock, so a step of the wall clock doesn't move them
+	now   func() time.Time
+	start time.Time
+
+	mutex   sync.RWMutex
+	buckets []*HDRHistogram
+	// slots are the time slots (time / bucketDuration) of the buckets
+	slots []int64
+	// current is the slot of the last rotation
+	current int64
+	window  *HDRHistogram
+}
+
+// NewRollingHistogram constructs a rolling histogram of buckets of
+// bucketDuration, every bucket is a HDRHistogram of lowest, highest and
+// significantFigures.
+func NewRollingHistogram(buckets int, bucketDuration time.Duration, lowest, highest int64, significantFigures int) *RollingHistogram {
+	if buckets < 1 {
+		buckets = 1
+	}
+	if bucketDuration <= 0 {
+		bucketDuration = time.Second
+	}
+	h := &RollingHistogram{
+		bucketDuration: bucketDuration,
+		now:            time.Now,
+		start:          time.Now(),
+		buckets:        make([]*HDRHistogram, buckets),
+		slots:          make([]int64, buckets),
+		window:         NewHDRHistogram(lowest, highest, significantFigures),
+	}
+	for i := range h.buckets {
+		h.buckets[i] = NewHDRHistogram(lowest, highest, significantFigures)
+		h.slots[i] = math.MinInt64
+	}
+	h.current = math.MinInt64
+	return h
+}
+
+func (h *RollingHistogram) slot() int64 {
+	return int64(h.now().Sub(h.start) / h.bucketDuration)
+}
+
+// index is the position of the bucket of the slot
+func (h *RollingHistogram) index(slot int64) int {
+	index := int(slot % int64(len(h.buckets)))
+	if index < 0 {
+		index += len(h.buckets)
+	}
+	return index
+}
+
+// rotate removes the buckets that left the window, when the time slot changed
+// since the last rotation. It returns the current slot.
+func (h *RollingHistogram) rotate() int64 {
+	slot := h.slot()
+	if atomic.LoadInt64(&h.current) == slot {
+		return slot
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if slot <= h.current {
+		// another call rotated to this slot or a newer one
+		return slot
+	}
+	oldest := slot - int64(len(h.buckets)) + 1
+	expired := false
+	for i, bucket := range h.buckets {
+		if h.slots[i] >= oldest {
+			continue
+		}
+		if bucket.Count() > 0 {
+			h.window.subtract(bucket)
+			bucket.Clear()
+			expired = true
+		}
+		h.slots[i] = math.MinInt64
+	}
+	h.slots[h.index(slot)] = slot
+	if expired {
+		h.resetMinMax()
+	}
+	atomic.StoreInt64(&h.current, slot)
+	return slot
+}
+
+// resetMinMax sets the min and the max of the window to the ones of the live
+// buckets, the mutex must be locked.
+func (h *RollingHistogram) resetMinMax() {
+	min, max := int64(math.MaxInt64), int64(0)
+	for _, bucket := range h.buckets {
+		if bucket.Count() == 0 {
+			continue
+		}
+		if m := atomic.LoadInt64(&bucket.min); m < min {
+			min = m
+		}
+		if m := atomic.LoadInt64(&bucket.max); m > max {
+			max = m
+		}
+	}
+	atomic.StoreInt64(&h.window.min, min)
+	atomic.StoreInt64(&h.window.max, max)
+}
+
+// Clear clears all samples.
+func (h *RollingHistogram) Clear() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bucket := range h.buckets {
+		bucket.Clear()
+		h.slots[i] = math.MinInt64
+	}
+	h.window.Clear()
+	atomic.StoreInt64(&h.current, math.MinInt64)
+}
+
+// Count returns the number of samples in the window.
+func (h *RollingHistogram) Count() int64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Count()
+}
+
+// Max returns the maximum value in the window.
+func (h *RollingHistogram) Max() int64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Max()
+}
+
+// Mean returns the mean of the values in the window.
+func (h *RollingHistogram) Mean() float64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Mean()
+}
+
+// Min returns the minimum value in the window.
+func (h *RollingHistogram) Min() int64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Min()
+}
+
+// Percentile returns an arbitrary percentile of the values in the window.
+func (h *RollingHistogram) Percentile(p float64) float64 {
+	return h.Percentiles([]float64{p})[0]
+}
+
+// Percentiles returns a slice of arbitrary percentiles of the values in the
+// window.
+func (h *RollingHistogram) Percentiles(ps []float64) []float64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Percentiles(ps)
+}
+
+// Size returns the number of samples in the window.
+func (h *RollingHistogram) Size() int {
+	return int(h.Count())
+}
+
+// Snapshot returns a read-only copy of the window, a HDRHistogram.
+func (h *RollingHistogram) Snapshot() Sample {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Snapshot()
+}
+
+// StdDev returns the standard deviation of the values in the window.
+func (h *RollingHistogram) StdDev() float64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.StdDev()
+}
+
+// Sum returns the sum of the values in the window.
+func (h *RollingHistogram) Sum() int64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Sum()
+}
+
+// Update records a value in the current bucket.
+func (h *RollingHistogram) Update(v int64) {
+	for {
+		slot := h.rotate()
+		h.mutex.RLock()
+		if slot < h.current {
+			// rotated to a newer slot meanwhile, the value goes to the current bucket
+			slot = h.current
+		}
+		index := h.index(slot)
+		if h.slots[index] == slot {
+			h.buckets[index].Update(v)
+			h.window.Update(v)
+			h.mutex.RUnlock()
+			return
+		}
+		// cleared meanwhile
+		h.mutex.RUnlock()
+	}
+}
+
+// Values returns a value for every counter of the window that is not empty.
+func (h *RollingHistogram) Values() []int64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Values()
+}
+
+// Variance returns the variance of the values in the window.
+func (h *RollingHistogram) Variance() float64 {
+	h.rotate()
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.window.Variance()
+}
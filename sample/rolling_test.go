@@ -0,0 +1,149 @@
+package sample
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func BenchmarkRollingHistogram(b *testing.B) {
+	benchmarkSample(b, NewRollingHistogram(10, time.Second, 1000, 60e9, 2))
+}
+
+// rollingForTest is a rolling histogram with a clock moved by the test
+func rollingForTest(buckets int) (*RollingHistogram, *time.Time) {
+	now := time.Unix(1000, 0)
+	h := NewRollingHistogram(buckets, time.Second, 1, 1e9, 3)
+	h.now = func() time.Time { return now }
+	h.start = now
+	return h, &now
+}
+
+func TestRollingHistogramWindow(t *testing.T) {
+	h, now := rollingForTest(4)
+	for i := 0; i < 100; i++ {
+		h.Update(1000)
+	}
+	*now = now.Add(time.Second)
+	for i := 0; i < 100; i++ {
+		h.Update(5000)
+	}
+
+	if count := h.Count(); count != 200 {
+		t.Errorf("h.Count(): 200 != %v\n", count)
+	}
+	if p := h.Percentile(0.25); p != 1000 {
+		t.Errorf("h.Percentile(0.25): 1000 != %v\n", p)
+	}
+	if p := h.Percentile(0.99); p != 5000 {
+		t.Errorf("h.Percentile(0.99): 5000 != %v\n", p)
+	}
+
+	// the first bucket leaves the window
+	*now = now.Add(3 * time.Second)
+	if count := h.Count(); count != 100 {
+		t.Errorf("h.Count(): 100 != %v\n", count)
+	}
+	if p := h.Percentile(0.25); p != 5000 {
+		t.Errorf("h.Percentile(0.25): 5000 != %v\n", p)
+	}
+	if min := h.Min(); min != 5000 {
+		t.Errorf("h.Min(): 5000 != %v\n", min)
+	}
+	if sum := h.Sum(); sum != 500000 {
+		t.Errorf("h.Sum(): 500000 != %v\n", sum)
+	}
+
+	// and then the second one
+	*now = now.Add(time.Second)
+	if count := h.Count(); count != 0 {
+		t.Errorf("h.Count(): 0 != %v\n", count)
+	}
+	if p := h.Percentile(0.99); p != 0 {
+		t.Errorf("h.Percentile(0.99): 0 != %v\n", p)
+	}
+	if max := h.Max(); max != 0 {
+		t.Errorf("h.Max(): 0 != %v\n", max)
+	}
+}
+
+func TestRollingHistogramReusesBuckets(t *testing.T) {
+	h, now := rollingForTest(2)
+	for i := 0; i < 10; i++ {
+		h.Update(int64(100 * (i + 1)))
+		*now = now.Add(time.Second)
+	}
+	// the window is the last 2 seconds, the last update is 1 second ago
+	if count := h.Count(); count != 1 {
+		t.Errorf("h.Count(): 1 != %v\n", count)
+	}
+	if max := h.Max(); max != 1000 {
+		t.Errorf("h.Max(): 1000 != %v\n", max)
+	}
+}
+
+func TestRollingHistogramClockBackwards(t *testing.T) {
+	h, now := rollingForTest(4)
+	h.Update(10)
+	// the value goes to the current bucket, Update doesn't wait for the clock
+	*now = now.Add(-5 * time.Second)
+	h.Update(20)
+	if count := h.Count(); count != 2 {
+		t.Errorf("h.Count(): 2 != %v\n", count)
+	}
+	if max := h.Max(); max != 20 {
+		t.Errorf("h.Max(): 20 != %v\n", max)
+	}
+}
+
+func TestRollingHistogramClear(t *testing.T) {
+	h, _ := rollingForTest(4)
+	h.Update(10)
+	h.Clear()
+	if count := h.Count(); count != 0 {
+		t.Errorf("h.Count(): 0 != %v\n", count)
+	}
+	h.Update(20)
+	if mean := h.Mean(); mean != 20 {
+		t.Errorf("h.Mean(): 20 != %v\n", mean)
+	}
+}
+
+func TestRollingHistogramSnapshot(t *testing.T) {
+	h, now := rollingForTest(4)
+	h.Update(10)
+	snapshot := h.Snapshot()
+	h.Update(20)
+	*now = now.Add(10 * time.Second)
+	if count := snapshot.Count(); count != 1 {
+		t.Errorf("snapshot.Count(): 1 != %v\n", count)
+	}
+	if max := snapshot.Max(); max != 10 {
+		t.Errorf("snapshot.Max(): 10 != %v\n", max)
+	}
+}
+
+func TestRollingHistogramConcurrent(t *testing.T) {
+	h := NewRollingHistogram(3, time.Millisecond, 1, 1e9, 2)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				h.Update(int64(i + 1))
+				if i%100 == 0 {
+					h.Percentile(0.99)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	snapshot := h.Snapshot().(*HDRHistogram)
+	if count := snapshot.Count(); count < 0 || count > 40000 {
+		t.Errorf("snapshot.Count(): %v\n", count)
+	}
+	if total := snapshot.totalCounts(); total != snapshot.Count() {
+		t.Errorf("the counters %v != snapshot.Count() %v\n", total, snapshot.Count())
+	}
+}
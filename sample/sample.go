@@ -62,7 +62,7 @@ func NewExpDecaySample(reservoirSize int, alpha float64) Sample {
 func (s *ExpDecaySample) Clear() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.count = 0
+	atomic.StoreInt64(&s.count, 0)
 	s.t0 = time.Now()
 	s.t1 = s.t0.Add(rescaleThreshold)
 	s.values = make(expDecaySampleHeap, 0, s.reservoirSize)
@@ -159,7 +159,8 @@ func (s *ExpDecaySample) Variance() float64 {
 func (s *ExpDecaySample) update(t time.Time, v int64) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.count++
+	// Count reads it without the lock
+	atomic.AddInt64(&s.count, 1)
 	if len(s.values) == s.reservoirSize {
 		heap.Pop(&s.values)
 	}
@@ -402,7 +403,7 @@ func NewUniformSample(reservoirSize int) Sample {
 func (s *UniformSample) Clear() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.count = 0
+	atomic.StoreInt64(&s.count, 0)
 	s.values = make([]int64, 0, s.reservoirSize)
 }
 
@@ -487,7 +488,8 @@ func (s *UniformSample) Sum() int64 {
 func (s *UniformSample) Update(v int64) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.count++
+	// Count reads it without the lock
+	atomic.AddInt64(&s.count, 1)
 	if len(s.values) < s.reservoirSize {
 		s.values = append(s.values, v)
 	} else {
@@ -0,0 +1,495 @@
+package sample
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultSketchAccuracy is the relative accuracy of the sketches when
+// NewSketch gets an invalid one, 1%.
+const DefaultSketchAccuracy = 0.01
+
+// MinSketchAccuracy is the smallest relative accuracy, the bins of smaller
+// ones don't fit in an int32.
+const MinSketchAccuracy = 1e-6
+
+// sketchVersion is the first byte of the binary encoding
+const sketchVersion = 1
+
+var (
+	// ErrIncompatibleSketch is returned by Merge when the sketches have
+	// different relative accuracies.
+	ErrIncompatibleSketch = errors.New("sample: the sketches have different relative accuracy")
+	// ErrInvalidSketch is returned when a serialised sketch is not valid.
+	ErrInvalidSketch = errors.New("sample: invalid sketch encoding")
+)
+
+// Sketch is a mergeable histogram of logarithmic bins (a DDSketch): the
+// percentiles have a relative error of at most the relative accuracy, for any
+// range of values, and the sketches of many processes can be merged into
+// one with the same accuracy, for example to aggregate the latencies of a
+// command in all the instances of a service.
+//
+// The sketches are serialised with MarshalBinary and MarshalJSON.
+// The values <= 0 are counted in a bin of zeros.
+type Sketch struct {
+	relativeAccuracy float64
+	gamma            float64
+	logGamma         float64
+
+	mutex sync.Mutex
+	// bins counts the values v with gamma^(i-1) < v <= gamma^i in bins[i]
+	bins      map[int32]int64
+	zeroCount int64
+	count     int64
+	sum       int64
+	min       int64
+	max       int64
+}
+
+// NewSketch constructs an empty sketch with relativeAccuracy (0.01 is 1% of
+// error), DefaultSketchAccuracy if it's not between MinSketchAccuracy and 1.
+func NewSketch(relativeAccuracy float64) *Sketch {
+	if !validAccuracy(relativeAccuracy) {
+		relativeAccuracy = DefaultSketchAccuracy
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	s := &Sketch{
+		relativeAccuracy: relativeAccuracy,
+		gamma:            gamma,
+		logGamma:         math.Log(gamma),
+	}
+	s.clear()
+	return s
+}
+
+func validAccuracy(relativeAccuracy float64) bool {
+	return relativeAccuracy >= MinSketchAccuracy && relativeAccuracy < 1
+}
+
+// NewSketchOf constructs a sketch with the values of the sample. The
+// histograms (HDRHistogram and RollingHistogram) add all their counters, the
+// other samples add their Values. The values of the histograms have the error
+// of both the histogram and the sketch.
+func NewSketchOf(sample Sample, relativeAccuracy float64) *Sketch {
+	s := NewSketch(relativeAccuracy)
+	switch h := sample.Snapshot().(type) {
+	case *HDRHistogram:
+		for i := range h.counts {
+			if n := h.counts[i]; n > 0 {
+				s.add(h.value(i), n)
+			}
+		}
+		if h.count > 0 {
+			// the exact ones, instead of the values of the counters
+			s.sum, s.min, s.max = h.sum, h.min, h.max
+		}
+	default:
+		for _, v := range h.Values() {
+			s.add(v, 1)
+		}
+	}
+	return s
+}
+
+// RelativeAccuracy returns the relative accuracy of the sketch.
+func (s *Sketch) RelativeAccuracy() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.relativeAccuracy
+}
+
+func (s *Sketch) index(v int64) int32 {
+	return int32(math.Ceil(math.Log(float64(v)) / s.logGamma))
+}
+
+// binValue is the value that represents the bin, the one with the same
+// relative error to both limits of the bin.
+func (s *Sketch) binValue(index int32) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+// add adds n times the value, the mutex must be locked.
+func (s *Sketch) add(v int64, n int64) {
+	if v <= 0 {
+		s.zeroCount += n
+	} else {
+		s.bins[s.index(v)] += n
+	}
+	s.count += n
+	s.sum += v * n
+	if v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+}
+
+func (s *Sketch) clear() {
+	s.bins = make(map[int32]int64)
+	s.zeroCount = 0
+	s.count = 0
+	s.sum = 0
+	s.min = math.MaxInt64
+	s.max = math.MinInt64
+}
+
+// Clear clears all samples.
+func (s *Sketch) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clear()
+}
+
+// Count returns the number of samples recorded.
+func (s *Sketch) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// Max returns the maximum value recorded.
+func (s *Sketch) Max() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return s.max
+}
+
+// Mean returns the mean of the values recorded.
+func (s *Sketch) Mean() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count == 0 {
+		return 0.0
+	}
+	return float64(s.sum) / float64(s.count)
+}
+
+// Merge adds the values of other, a sketch with the same relative accuracy.
+func (s *Sketch) Merge(other *Sketch) error {
+	o := other.copy()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.relativeAccuracy != o.relativeAccuracy {
+		return ErrIncompatibleSketch
+	}
+	for index, n := range o.bins {
+		s.bins[index] += n
+	}
+	s.zeroCount += o.zeroCount
+	s.count += o.count
+	s.sum += o.sum
+	if o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+	return nil
+}
+
+// Min returns the minimum value recorded.
+func (s *Sketch) Min() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count == 0 {
+		return 0
+	}
+	return s.min
+}
+
+// Percentile returns an arbitrary percentile of the values recorded.
+func (s *Sketch) Percentile(p float64) float64 {
+	return s.Percentiles([]float64{p})[0]
+}
+
+// Percentiles returns a slice of arbitrary percentiles of the values
+// recorded, with the relative error of the sketch.
+func (s *Sketch) Percentiles(ps []float64) []float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scores := make([]float64, len(ps))
+	if s.count == 0 {
+		return scores
+	}
+	indexes := s.sortedIndexes()
+	for i, p := range ps {
+		rank := int64(math.Ceil(p * float64(s.count)))
+		if rank < 1 {
+			rank = 1
+		}
+		seen := s.zeroCount
+		if seen >= rank {
+			scores[i] = s.clamp(0)
+			continue
+		}
+		for _, index := range indexes {
+			seen += s.bins[index]
+			if seen >= rank {
+				scores[i] = s.clamp(s.binValue(index))
+				break
+			}
+		}
+	}
+	return scores
+}
+
+// clamp clamps the value of a bin by the min and the max recorded, the
+// mutex must be locked.
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(float64(s.min), math.Min(float64(s.max), v))
+}
+
+// sortedIndexes returns the indexes of the bins, the mutex must be locked.
+func (s *Sketch) sortedIndexes() []int32 {
+	indexes := make([]int32, 0, len(s.bins))
+	for index := range s.bins {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes
+}
+
+// Size returns the number of samples recorded.
+func (s *Sketch) Size() int {
+	return int(s.Count())
+}
+
+// Snapshot returns a copy of the sketch.
+func (s *Sketch) Snapshot() Sample {
+	return s.copy()
+}
+
+func (s *Sketch) copy() *Sketch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := &Sketch{
+		relativeAccuracy: s.relativeAccuracy,
+		gamma:            s.gamma,
+		logGamma:         s.logGamma,
+		bins:             make(map[int32]int64, len(s.bins)),
+		zeroCount:        s.zeroCount,
+		count:            s.count,
+		sum:              s.sum,
+		min:              s.min,
+		max:              s.max,
+	}
+	for index, n := range s.bins {
+		c.bins[index] = n
+	}
+	return c
+}
+
+// StdDev returns the standard deviation of the values recorded.
+func (s *Sketch) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// Sum returns the sum of the values recorded.
+func (s *Sketch) Sum() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sum
+}
+
+// Update records a value.
+func (s *Sketch) Update(v int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.add(v, 1)
+}
+
+// Values returns the value of every bin that is not empty, at the precision of
+// the sketch (not a value for every sample).
+func (s *Sketch) Values() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	values := make([]int64, 0, len(s.bins)+1)
+	if s.zeroCount > 0 {
+		values = append(values, int64(s.clamp(0)))
+	}
+	for _, index := range s.sortedIndexes() {
+		values = append(values, int64(math.Round(s.clamp(s.binValue(index)))))
+	}
+	return values
+}
+
+// Variance returns the variance of the values recorded, computed with the
+// values of the bins.
+func (s *Sketch) Variance() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.count == 0 {
+		return 0.0
+	}
+	mean := float64(s.sum) / float64(s.count)
+	d := s.clamp(0) - mean
+	sum := d * d * float64(s.zeroCount)
+	for index, n := range s.bins {
+		d := s.clamp(s.binValue(index)) - mean
+		sum += d * d * float64(n)
+	}
+	return sum / float64(s.count)
+}
+
+// MarshalBinary encodes the sketch: the version, the relative accuracy, the
+// count, the sum, the min, the max, the zeros and the bins (the delta of the
+// index and the count) as varints.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	c := s.copy()
+	indexes := c.sortedIndexes()
+
+	buffer := make([]byte, 0, 9+binary.MaxVarintLen64*(6+2*len(indexes)))
+	buffer = append(buffer, sketchVersion)
+	buffer = binary.LittleEndian.AppendUint64(buffer, math.Float64bits(c.relativeAccuracy))
+	buffer = binary.AppendVarint(buffer, c.count)
+	buffer = binary.AppendVarint(buffer, c.sum)
+	buffer = binary.AppendVarint(buffer, c.min)
+	buffer = binary.AppendVarint(buffer, c.max)
+	buffer = binary.AppendVarint(buffer, c.zeroCount)
+	buffer = binary.AppendUvarint(buffer, uint64(len(indexes)))
+	previous := int64(0)
+	for _, index := range indexes {
+		buffer = binary.AppendVarint(buffer, int64(index)-previous)
+		buffer = binary.AppendVarint(buffer, c.bins[index])
+		previous = int64(index)
+	}
+	return buffer, nil
+}
+
+// UnmarshalBinary replaces the sketch with the one encoded by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 || data[0] != sketchVersion {
+		return ErrInvalidSketch
+	}
+	relativeAccuracy := math.Float64frombits(binary.LittleEndian.Uint64(data[1:9]))
+	data = data[9:]
+
+	var err error
+	varint := func() int64 {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			err = ErrInvalidSketch
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	count, sum, min, max, zeroCount := varint(), varint(), varint(), varint(), varint()
+	if err != nil {
+		return err
+	}
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)) {
+		return ErrInvalidSketch
+	}
+	data = data[n:]
+
+	bins := make(map[int32]int64, length)
+	index := int64(0)
+	for i := uint64(0); i < length; i++ {
+		index += varint()
+		bins[int32(index)] = varint()
+		if err != nil {
+			return err
+		}
+		if index < math.MinInt32 || index > math.MaxInt32 {
+			return ErrInvalidSketch
+		}
+	}
+	if len(data) != 0 {
+		return ErrInvalidSketch
+	}
+	return s.set(relativeAccuracy, bins, zeroCount, count, sum, min, max)
+}
+
+// sketchJSON is the JSON of a sketch, the bins are index: count
+type sketchJSON struct {
+	RelativeAccuracy float64          `json:"relativeAccuracy"`
+	Count            int64            `json:"count"`
+	Sum              int64            `json:"sum"`
+	Min              int64            `json:"min"`
+	Max              int64            `json:"max"`
+	ZeroCount        int64            `json:"zeroCount"`
+	Bins             map[string]int64 `json:"bins"`
+}
+
+// MarshalJSON encodes the sketch as a JSON object
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	c := s.copy()
+	j := sketchJSON{
+		RelativeAccuracy: c.relativeAccuracy,
+		Count:            c.count,
+		Sum:              c.sum,
+		Min:              c.min,
+		Max:              c.max,
+		ZeroCount:        c.zeroCount,
+		Bins:             make(map[string]int64, len(c.bins)),
+	}
+	for index, n := range c.bins {
+		j.Bins[strconv.Itoa(int(index))] = n
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON replaces the sketch with the one encoded by MarshalJSON.
+func (s *Sketch) UnmarshalJSON(data []byte) error {
+	var j sketchJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	bins := make(map[int32]int64, len(j.Bins))
+	for key, n := range j.Bins {
+		index, err := strconv.ParseInt(key, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: bin %q", ErrInvalidSketch, key)
+		}
+		bins[int32(index)] = n
+	}
+	return s.set(j.RelativeAccuracy, bins, j.ZeroCount, j.Count, j.Sum, j.Min, j.Max)
+}
+
+// set replaces the sketch after checking that the values are consistent.
+func (s *Sketch) set(relativeAccuracy float64, bins map[int32]int64, zeroCount, count, sum, min, max int64) error {
+	if !validAccuracy(relativeAccuracy) || zeroCount < 0 {
+		return ErrInvalidSketch
+	}
+	total := zeroCount
+	for index, n := range bins {
+		if n <= 0 {
+			return fmt.Errorf("%w: bin %d has count %d", ErrInvalidSketch, index, n)
+		}
+		total += n
+	}
+	if total != count {
+		return fmt.Errorf("%w: count %d is not the sum of the bins %d", ErrInvalidSketch, count, total)
+	}
+	if count == 0 {
+		min, max = math.MaxInt64, math.MinInt64
+	} else if min > max {
+		return fmt.Errorf("%w: min %d is greater than max %d", ErrInvalidSketch, min, max)
+	}
+
+	e := NewSketch(relativeAccuracy)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.relativeAccuracy, s.gamma, s.logGamma = e.relativeAccuracy, e.gamma, e.logGamma
+	s.bins = bins
+	s.zeroCount = zeroCount
+	s.count = count
+	s.sum = sum
+	s.min = min
+	s.max = max
+	return nil
+}
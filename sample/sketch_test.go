@@ -0,0 +1,248 @@
+package sample
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func BenchmarkSketch(b *testing.B) {
+	benchmarkSample(b, NewSketch(0.01))
+}
+
+// sketchDistributions are latencies in ns of different shapes
+var sketchDistributions = map[string]func(r *rand.Rand) int64{
+	"uniform": func(r *rand.Rand) int64 {
+		return 1 + r.Int63n(int64(time.Second))
+	},
+	"exponential": func(r *rand.Rand) int64 {
+		return 1 + int64(r.ExpFloat64()*float64(10*time.Millisecond))
+	},
+	"lognormal": func(r *rand.Rand) int64 {
+		return 1 + int64(math.Exp(r.NormFloat64()*2+15))
+	},
+	"bimodal": func(r *rand.Rand) int64 {
+		if r.Intn(100) < 95 {
+			return int64(time.Millisecond) + r.Int63n(int64(time.Millisecond))
+		}
+		return int64(time.Second) + r.Int63n(int64(time.Second))
+	},
+}
+
+// testSketchAccuracy checks that the percentiles of the sketch are within the
+// relative accuracy of the exact ones of the values
+func testSketchAccuracy(t *testing.T, name string, s *Sketch, values []int64) {
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, p := range []float64{0.01, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999, 1} {
+		rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+		expected := float64(sorted[rank])
+		actual := s.Percentile(p)
+		if math.Abs(actual-expected)/expected > s.RelativeAccuracy() {
+			t.Errorf("%s: s.Percentile(%v): %v != %v\n", name, p, expected, actual)
+		}
+	}
+	if count := s.Count(); count != int64(len(values)) {
+		t.Errorf("%s: s.Count(): %v != %v\n", name, len(values), count)
+	}
+	if min := s.Min(); min != sorted[0] {
+		t.Errorf("%s: s.Min(): %v != %v\n", name, sorted[0], min)
+	}
+	if max := s.Max(); max != sorted[len(sorted)-1] {
+		t.Errorf("%s: s.Max(): %v != %v\n", name, sorted[len(sorted)-1], max)
+	}
+}
+
+func TestSketchAccuracy(t *testing.T) {
+	for name, distribution := range sketchDistributions {
+		for _, accuracy := range []float64{0.05, 0.01, 0.001} {
+			r := rand.New(rand.NewSource(1))
+			s := NewSketch(accuracy)
+			values := make([]int64, 50000)
+			for i := range values {
+				values[i] = distribution(r)
+				s.Update(values[i])
+			}
+			testSketchAccuracy(t, name, s, values)
+		}
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	for name, distribution := range sketchDistributions {
+		r := rand.New(rand.NewSource(2))
+		// a sketch per process, with a different amount of values each
+		all := NewSketch(0.01)
+		merged := NewSketch(0.01)
+		var values []int64
+		for process := 0; process < 10; process++ {
+			s := NewSketch(0.01)
+			for i := 0; i < 1000*(process+1); i++ {
+				v := distribution(r)
+				values = append(values, v)
+				s.Update(v)
+				all.Update(v)
+			}
+			if err := merged.Merge(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testSketchAccuracy(t, name, merged, values)
+
+		// merging is the same as recording all the values in a sketch
+		if !reflect.DeepEqual(merged.bins, all.bins) {
+			t.Errorf("%s: the merged bins are not the bins of all the values\n", name)
+		}
+		if merged.Sum() != all.Sum() {
+			t.Errorf("%s: merged.Sum(): %v != %v\n", name, all.Sum(), merged.Sum())
+		}
+	}
+}
+
+func TestSketchMergeIncompatible(t *testing.T) {
+	s := NewSketch(0.01)
+	s.Update(10)
+	if err := s.Merge(NewSketch(0.02)); err != ErrIncompatibleSketch {
+		t.Errorf("s.Merge(): ErrIncompatibleSketch != %v\n", err)
+	}
+	if err := s.Merge(s); err != nil {
+		t.Fatal(err)
+	}
+	if count := s.Count(); count != 2 {
+		t.Errorf("s.Count(): 2 != %v\n", count)
+	}
+}
+
+func TestSketchZerosAndEmpty(t *testing.T) {
+	s := NewSketch(0.01)
+	if p := s.Percentile(0.5); p != 0 {
+		t.Errorf("s.Percentile(0.5): 0 != %v\n", p)
+	}
+	if max := s.Max(); max != 0 {
+		t.Errorf("s.Max(): 0 != %v\n", max)
+	}
+	s.Update(0)
+	s.Update(-5)
+	s.Update(100)
+	if p := s.Percentile(0.5); p != 0 {
+		t.Errorf("s.Percentile(0.5): 0 != %v\n", p)
+	}
+	if p := s.Percentile(1); p != 100 {
+		t.Errorf("s.Percentile(1): 100 != %v\n", p)
+	}
+	if min := s.Min(); min != -5 {
+		t.Errorf("s.Min(): -5 != %v\n", min)
+	}
+}
+
+// sketchForTest is a sketch of long tail latencies
+func sketchForTest() *Sketch {
+	r := rand.New(rand.NewSource(3))
+	s := NewSketch(0.01)
+	for i := 0; i < 10000; i++ {
+		s.Update(sketchDistributions["lognormal"](r))
+	}
+	s.Update(0)
+	return s
+}
+
+func testSketchEqual(t *testing.T, expected, actual *Sketch) {
+	if actual.RelativeAccuracy() != expected.RelativeAccuracy() ||
+		!reflect.DeepEqual(actual.bins, expected.bins) ||
+		actual.zeroCount != expected.zeroCount || actual.count != expected.count ||
+		actual.sum != expected.sum || actual.min != expected.min || actual.max != expected.max {
+		t.Errorf("the sketches are different\n")
+	}
+	if !reflect.DeepEqual(actual.Percentiles([]float64{0.5, 0.99}), expected.Percentiles([]float64{0.5, 0.99})) {
+		t.Errorf("the percentiles are different\n")
+	}
+}
+
+func TestSketchBinary(t *testing.T) {
+	s := sketchForTest()
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewSketch(0.05)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	testSketchEqual(t, s, decoded)
+
+	// and an empty one
+	data, _ = NewSketch(0.01).MarshalBinary()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if count := decoded.Count(); count != 0 {
+		t.Errorf("decoded.Count(): 0 != %v\n", count)
+	}
+
+	for _, invalid := range [][]byte{nil, {2, 0, 0, 0, 0, 0, 0, 0, 0}, data[:len(data)-1], append(data, 0)} {
+		if err := decoded.UnmarshalBinary(invalid); !errors.Is(err, ErrInvalidSketch) {
+			t.Errorf("decoded.UnmarshalBinary(%v): ErrInvalidSketch != %v\n", invalid, err)
+		}
+	}
+}
+
+func TestSketchJSON(t *testing.T) {
+	s := sketchForTest()
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewSketch(0.05)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	testSketchEqual(t, s, decoded)
+
+	for _, invalid := range []string{
+		`{"relativeAccuracy": 2, "count": 0}`,
+		`{"relativeAccuracy": 0.01, "count": 3, "bins": {"10": 2}}`,
+		`{"relativeAccuracy": 0.01, "count": 2, "bins": {"10": 2}, "min": 20, "max": 10}`,
+		`{"relativeAccuracy": 0.01, "count": 2, "bins": {"ten": 2}}`,
+	} {
+		if err := json.Unmarshal([]byte(invalid), decoded); !errors.Is(err, ErrInvalidSketch) {
+			t.Errorf("json.Unmarshal(%s): ErrInvalidSketch != %v\n", invalid, err)
+		}
+	}
+}
+
+func TestSketchOf(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	h := NewHDRHistogram(1000, 60e9, 3)
+	var values []int64
+	for i := 0; i < 20000; i++ {
+		v := sketchDistributions["bimodal"](r)
+		values = append(values, v)
+		h.Update(v)
+	}
+	// the error of the histogram (0.1%) and of the sketch
+	s := NewSketchOf(h, 0.01)
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		expected := float64(sorted[int(math.Ceil(p*float64(len(sorted))))-1])
+		if actual := s.Percentile(p); math.Abs(actual-expected)/expected > 0.011 {
+			t.Errorf("s.Percentile(%v): %v != %v\n", p, expected, actual)
+		}
+	}
+	if s.Count() != h.Count() || s.Sum() != h.Sum() || s.Max() != h.Max() {
+		t.Errorf("the sketch has not the count, the sum and the max of the histogram\n")
+	}
+
+	e := NewExpDecaySample(100, 0.99)
+	for i := 1; i <= 10; i++ {
+		e.Update(int64(i))
+	}
+	if count := NewSketchOf(e, 0.01).Count(); count != 10 {
+		t.Errorf("NewSketchOf(e).Count(): 10 != %v\n", count)
+	}
+}
@@ -0,0 +1,156 @@
+package goHystrix
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	logger      *slog.Logger
+	loggerMutex sync.RWMutex
+)
+
+// SetLogger sets the logger for the errors of the commands (the error before the fallback)
+// and of the exporters, nil uses log.Println
+func SetLogger(l *slog.Logger) {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	logger = l
+}
+
+// Logger returns the logger of SetLogger, nil if there is none
+func Logger() *slog.Logger {
+	loggerMutex.RLock()
+	defer loggerMutex.RUnlock()
+	return logger
+}
+
+// logEvent logs the event with the Logger and the attributes,
+// without Logger it logs the text with log.Println
+func logEvent(level slog.Level, event string, text string, attrs ...any) {
+	l := Logger()
+	if l == nil {
+		log.Println(text)
+		return
+	}
+	l.Log(context.Background(), level, event, attrs...)
+}
+
+// SlogExport logs the events of the commands with a slog.Logger,
+// with the attributes group and name (and duration, state):
+//
+//	command.success, command.failure, command.timeout, command.panic, command.fallback,
+//	fallback.failed, command.hedged, command.throttled, command.rejected,
+//	circuit.opened, circuit.closed
+//
+// The success events are at Debug level and the failures at Warn or Error, the failures, the timeouts
+// and the rejections of the commands have the attribute error.
+// The circuit events are logged in State, when the state changed since the last call.
+type SlogExport struct {
+	logger *slog.Logger
+	// sampleSuccess logs one of every sampleSuccess success events
+	sampleSuccess int64
+	successes     int64
+
+	mutex sync.Mutex
+	open  map[string]bool
+}
+
+// NewSlogExport logs the events with the logger, one of every sampleSuccess success events
+// (all of them if sampleSuccess <= 1)
+func NewSlogExport(logger *slog.Logger, sampleSuccess int) *SlogExport {
+	if sampleSuccess < 1 {
+		sampleSuccess = 1
+	}
+	return &SlogExport{
+		logger:        logger,
+		sampleSuccess: int64(sampleSuccess),
+		open:          make(map[string]bool),
+	}
+}
+
+// UseSlog logs the events and the errors of the commands with the logger,
+// the changes of the circuits are checked every dur
+func UseSlog(logger *slog.Logger, sampleSuccess int, dur time.Duration) {
+	SetLogger(logger)
+	SetExporter(NewSlogExport(logger, sampleSuccess))
+	PollState(Circuits(), dur)
+}
+
+func (e *SlogExport) log(level slog.Level, event string, group string, name string, attrs ...any) {
+	e.logger.Log(context.Background(), level, event, append([]any{"group", group, "name", name}, attrs...)...)
+}
+
+func (e *SlogExport) Success(group string, name string, duration time.Duration) {
+	if (atomic.AddInt64(&e.successes, 1)-1)%e.sampleSuccess != 0 {
+		return
+	}
+	e.log(slog.LevelDebug, "command.success", group, name, "duration", duration)
+}
+
+func (e *SlogExport) Fail(group string, name string) {
+	e.log(slog.LevelWarn, "command.failure", group, name)
+}
+
+func (e *SlogExport) Fallback(group string, name string) {
+	e.log(slog.LevelInfo, "command.fallback", group, name)
+}
+
+func (e *SlogExport) FallbackError(group string, name string) {
+	e.log(slog.LevelError, "fallback.failed", group, name)
+}
+
+func (e *SlogExport) Timeout(group string, name string) {
+	e.log(slog.LevelWarn, "command.timeout", group, name)
+}
+
+func (e *SlogExport) Panic(group string, name string) {
+	e.log(slog.LevelError, "command.panic", group, name)
+}
+
+func (e *SlogExport) Hedge(group string, name string) {
+	e.log(slog.LevelDebug, "command.hedged", group, name)
+}
+
+func (e *SlogExport) Throttled(group string, name string) {
+	e.log(slog.LevelWarn, "command.throttled", group, name)
+}
+
+func (e *SlogExport) Rejected(group string, name string) {
+	e.log(slog.LevelWarn, "command.rejected", group, name)
+}
+
+func (e *SlogExport) FailWithError(group string, name string, err error) {
+	e.log(slog.LevelWarn, "command.failure", group, name, "error", err)
+}
+
+func (e *SlogExport) TimeoutWithError(group string, name string, err error) {
+	e.log(slog.LevelWarn, "command.timeout", group, name, "error", err)
+}
+
+func (e *SlogExport) RejectedWithError(group string, name string, err error) {
+	e.log(slog.LevelWarn, "command.rejected", group, name, "error", err)
+}
+
+// State logs circuit.opened and circuit.closed for the circuits that changed since the last call
+func (e *SlogExport) State(holder *CircuitHolder) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, circuit := range holder.sortedCircuits() {
+		open, state := circuit.IsOpen()
+		key := circuit.group + "/" + circuit.name
+		if e.open[key] == open {
+			continue
+		}
+		e.open[key] = open
+		if open {
+			e.log(slog.LevelError, "circuit.opened", circuit.group, circuit.name, "state", state)
+		} else {
+			e.log(slog.LevelInfo, "circuit.closed", circuit.group, circuit.name, "state", state)
+		}
+	}
+}
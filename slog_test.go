@@ -0,0 +1,151 @@
+package goHystrix
+
+import (
+	"context"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordHandler keeps the records as "level message key=value..."
+type recordHandler struct {
+	mutex   sync.Mutex
+	records []string
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	line := r.Level.String() + " " + r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		line += " " + a.String()
+		return true
+	})
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.records = append(h.records, line)
+	return nil
+}
+
+func (h *recordHandler) Records() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string{}, h.records...)
+}
+
+func TestSlogExport(t *testing.T) {
+	Convey("SlogExport logs the events of the commands", t, func() {
+		handler := &recordHandler{}
+		export := NewSlogExport(slog.New(handler), 1)
+
+		export.Success("group", "name", 20*time.Millisecond)
+		export.Timeout("group", "name")
+		export.FallbackError("group", "name")
+
+		So(handler.Records(), ShouldResemble, []string{
+			"DEBUG command.success group=group name=name duration=20ms",
+			"WARN command.timeout group=group name=name",
+			"ERROR fallback.failed group=group name=name",
+		})
+	})
+
+	Convey("The success events are sampled", t, func() {
+		handler := &recordHandler{}
+		export := NewSlogExport(slog.New(handler), 3)
+
+		for i := 0; i < 7; i++ {
+			export.Success("group", "name", time.Millisecond)
+		}
+		export.Fail("group", "name")
+		So(handler.Records(), ShouldHaveLength, 4)
+	})
+
+	Convey("State logs when the circuits open and close", t, func() {
+		CircuitsReset()
+		handler := &recordHandler{}
+		export := NewSlogExport(slog.New(handler), 1)
+		options := CommandOptionsForTest()
+		options.MinimumNumberOfRequest = 1
+		circuit := NewCircuit("testGroup", "slogCmd", options)
+
+		export.State(Circuits())
+		So(handler.Records(), ShouldBeEmpty)
+
+		circuit.Metric().Fail()
+		export.State(Circuits())
+		export.State(Circuits())
+		So(handler.Records(), ShouldResemble, []string{
+			"ERROR circuit.opened group=testGroup name=slogCmd state=OPEN: to many errors",
+		})
+
+		circuit.Metric().Success(time.Millisecond)
+		circuit.Metric().Success(time.Millisecond)
+		export.State(Circuits())
+		So(handler.Records()[1], ShouldEqual, "INFO circuit.closed group=testGroup name=slogCmd state=CLOSE: all ok")
+	})
+
+	Convey("The failures, timeouts and rejections have the error", t, func() {
+		handler := &recordHandler{}
+		export := NewSlogExport(slog.New(handler), 1)
+
+		exportFail(export, "group", "name", errors.New("fail"))
+		exportTimeout(export, "group", "name", errors.New("timeout"))
+		exportRejected(export, "group", "name", ErrMaxConcurrency)
+		exportFail(export, "group", "name", nil)
+
+		So(handler.Records(), ShouldResemble, []string{
+			"WARN command.failure group=group name=name error=fail",
+			"WARN command.timeout group=group name=name error=timeout",
+			"WARN command.rejected group=group name=name error=max concurrent requests reached",
+			"WARN command.failure group=group name=name",
+		})
+	})
+
+	Convey("The failures, timeouts and rejections of the commands have the error", t, func() {
+		CircuitsReset()
+		handler := &recordHandler{}
+		SetExporter(NewSlogExport(slog.New(handler), 1))
+		defer SetExporter(NewNilExport())
+
+		NewStringCommand("error", "fallback").Execute()
+		options := CommandOptionsForTest()
+		options.Timeout = time.Millisecond
+		MustNewCommand("slogTimeoutCmd", "testGroup", &StringCommand{state: "timeout"}, options).Execute()
+
+		var records []string
+		for i := 0; i < 100 && len(records) < 4; i++ {
+			time.Sleep(time.Millisecond)
+			records = handler.Records()
+		}
+		So(records, ShouldContain, "WARN command.failure group=testGroup name=testCommand error=ERROR: this method is mend to fail")
+		So(records, ShouldContain, "WARN command.timeout group=testGroup name=slogTimeoutCmd error=error: Timeout (1ms), executing command testGroup:slogTimeoutCmd")
+
+		Convey("Also through a MultiExport", func() {
+			multi := NewMultiExport(NewSlogExport(slog.New(handler), 1))
+			exportRejected(multi, "group", "name", ErrMaxConcurrency)
+			So(handler.Records(), ShouldContain, "WARN command.rejected group=group name=name error=max concurrent requests reached")
+		})
+	})
+
+	Convey("The logger of SetLogger logs the errors before the fallback", t, func() {
+		CircuitsReset()
+		handler := &recordHandler{}
+		SetLogger(slog.New(handler))
+		defer SetLogger(nil)
+
+		command := NewStringCommand("error", "fallback")
+		result, err := command.Execute()
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, "FALLBACK")
+
+		records := handler.Records()
+		So(records, ShouldHaveLength, 1)
+		So(records[0], ShouldStartWith, "WARN command.error group=testGroup name=testCommand error=")
+		So(records[0], ShouldContainSubstring, "this method is mend to fail")
+	})
+}
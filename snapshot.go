@@ -0,0 +1,116 @@
+package goHystrix
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CircuitSnapshot is the state of a circuit at a point in time, with typed values.
+// The durations are in ms.
+type CircuitSnapshot struct {
+	Name  string `json:"name"`
+	Group string `json:"group"`
+
+	IsOpen           bool    `json:"isOpen"`
+	State            string  `json:"state"`
+	TimeoutMs        float64 `json:"timeoutMs"`
+	ConcurrencyLimit int     `json:"concurrencyLimit"`
+	InFlight         int     `json:"inFlight"`
+	Queued           int     `json:"queued"`
+
+	Percentile50Ms float64 `json:"percentile50Ms"`
+	Percentile90Ms float64 `json:"percentile90Ms"`
+	Percentile99Ms float64 `json:"percentile99Ms"`
+	MeanMs         float64 `json:"meanMs"`
+	MaxMs          float64 `json:"maxMs"`
+	MinMs          float64 `json:"minMs"`
+
+	Total           int64   `json:"total"`
+	Success         int64   `json:"success"`
+	Failures        int64   `json:"failures"`
+	Timeouts        int64   `json:"timeouts"`
+	Fallback        int64   `json:"fallback"`
+	FallbackErrors  int64   `json:"fallbackErrors"`
+	Panics          int64   `json:"panics"`
+	Hedges          int64   `json:"hedges"`
+	Throttled       int64   `json:"throttled"`
+	Rejected        int64   `json:"rejected"`
+	ErrorPercentage float64 `json:"errorPercentage"`
+
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastTimeout time.Time `json:"lastTimeout"`
+}
+
+func durationMs(ns float64) float64 {
+	return ns / float64(time.Millisecond)
+}
+
+// Snapshot returns the state of the circuit
+func (c *CircuitBreaker) Snapshot() CircuitSnapshot {
+	open, state := c.IsOpen()
+	counts := c.Metric().HealthCounts()
+	stats := c.Metric().Stats()
+	limit, inFlight := c.ConcurrencyLimit()
+
+	s := CircuitSnapshot{
+		Name:             c.name,
+		Group:            c.group,
+		IsOpen:           open,
+		State:            state,
+		TimeoutMs:        durationMs(float64(c.Timeout())),
+		ConcurrencyLimit: limit,
+		InFlight:         inFlight,
+		Queued:           c.QueueDepth(),
+
+		Total:           counts.Total,
+		Success:         counts.Success,
+		Failures:        counts.Failures,
+		Timeouts:        counts.Timeouts,
+		Fallback:        counts.Fallback,
+		FallbackErrors:  counts.FallbackErrors,
+		Panics:          counts.Panics,
+		Hedges:          counts.Hedges,
+		Throttled:       counts.Throttled,
+		Rejected:        counts.Rejected,
+		ErrorPercentage: counts.ErrorPercentage,
+
+		LastSuccess: c.Metric().LastSuccess(),
+		LastFailure: c.Metric().LastFailure(),
+		LastTimeout: c.Metric().LastTimeout(),
+	}
+	if stats.Count() > 0 {
+		percentiles := stats.Percentiles([]float64{0.5, 0.9, 0.99})
+		s.Percentile50Ms = durationMs(percentiles[0])
+		s.Percentile90Ms = durationMs(percentiles[1])
+		s.Percentile99Ms = durationMs(percentiles[2])
+		s.MeanMs = durationMs(stats.Mean())
+		s.MaxMs = durationMs(float64(stats.Max()))
+		s.MinMs = durationMs(float64(stats.Min()))
+	}
+	return s
+}
+
+// Snapshot returns the state of all the circuits, sorted by group and name
+func (holder *CircuitHolder) Snapshot() []CircuitSnapshot {
+	circuits := holder.sortedCircuits()
+	snapshots := make([]CircuitSnapshot, 0, len(circuits))
+	for _, circuit := range circuits {
+		snapshots = append(snapshots, circuit.Snapshot())
+	}
+	return snapshots
+}
+
+// CircuitsVar is an expvar.Var with the snapshot of Circuits() in JSON,
+// httpexp publishes it as "circuits" in /debug/vars
+//
+//	expvar.Publish("circuits", goHystrix.CircuitsVar{})
+type CircuitsVar struct{}
+
+func (CircuitsVar) String() string {
+	b, err := json.Marshal(Circuits().Snapshot())
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
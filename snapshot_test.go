@@ -0,0 +1,49 @@
+package goHystrix
+
+import (
+	"encoding/json"
+	"expvar"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	Convey("Snapshot has the state of the circuits with typed values", t, func() {
+		CircuitsReset()
+		command := MustNewCommand("snapshotCmd", "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+		_, err := command.Execute()
+		So(err, ShouldBeNil)
+		NewCircuit("otherGroup", "otherCmd", CommandOptionsForTest())
+		circuit, _ := Circuits().Get("testGroup", "snapshotCmd")
+		circuit.Metric().HealthCounts() // the success is in the sample
+		circuit.Metric().Stats().Clear()
+		circuit.Metric().Stats().Update(int64(12500 * time.Microsecond))
+
+		snapshots := Circuits().Snapshot()
+		So(snapshots, ShouldHaveLength, 2)
+		So(snapshots[0].Group, ShouldEqual, "otherGroup")
+		So(snapshots[0].Total, ShouldEqual, 0)
+
+		s := snapshots[1]
+		So(s.Name, ShouldEqual, "snapshotCmd")
+		So(s.Group, ShouldEqual, "testGroup")
+		So(s.IsOpen, ShouldBeFalse)
+		So(s.TimeoutMs, ShouldEqual, 3)
+		So(s.Total, ShouldEqual, 1)
+		So(s.Success, ShouldEqual, 1)
+		So(s.Percentile50Ms, ShouldEqual, 12.5)
+		So(s.MaxMs, ShouldEqual, 12.5)
+		So(s.LastSuccess.IsZero(), ShouldBeFalse)
+
+		Convey("CircuitsVar is an expvar.Var with the snapshot in JSON", func() {
+			var v expvar.Var = CircuitsVar{}
+			var decoded []CircuitSnapshot
+			So(json.Unmarshal([]byte(v.String()), &decoded), ShouldBeNil)
+			So(decoded, ShouldHaveLength, 2)
+			So(decoded[1].Name, ShouldEqual, "snapshotCmd")
+			So(decoded[1].Success, ShouldEqual, 1)
+			So(decoded[1].Percentile50Ms, ShouldEqual, 12.5)
+		})
+	})
+}
@@ -0,0 +1,344 @@
+package statsd
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MTU_PACKET_SIZE fits in a ethernet frame, 1500 bytes MTU - 8-byte UDP header - 60-byte IP header
+	MTU_PACKET_SIZE = 1432
+
+	DEFAULT_MAX_QUEUE = 10000
+)
+
+// BufferedStatter is a Statter that doesn't write in every call, the counters are aggregated
+// in memory (and the gauges keep the last value), the timings are queued, and everything is sent
+// in packets of at most packetSize bytes every flushInterval, or before if there is a full packet.
+// The queue is bounded, the metrics that don't fit are dropped and counted in Dropped().
+type BufferedStatter struct {
+	w          io.Writer
+	packetSize int
+	maxQueue   int
+
+	mutex    sync.Mutex
+	counters map[string]*counterUpdate
+	gauges   map[string]*gaugeUpdate
+	deltas   map[string]*deltaUpdate
+	queue    []sendable
+	size     int
+	dropped  int64
+
+	flushChan chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// DialBuffered dials like Dial, and returns a BufferedStatter with MTU sized packets
+func DialBuffered(proto, endpoint string, flushInterval time.Duration) (*BufferedStatter, error) {
+	c, err := NewConn(proto, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return NewBuffered(c, flushInterval, MTU_PACKET_SIZE, DEFAULT_MAX_QUEUE), nil
+}
+
+// DialBufferedLazy is DialBuffered without dialing, it dials in the first flush
+func DialBufferedLazy(proto, endpoint string, flushInterval time.Duration) (*BufferedStatter, error) {
+	c, err := NewConn(proto, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return NewBuffered(c, flushInterval, MTU_PACKET_SIZE, DEFAULT_MAX_QUEUE), nil
+}
+
+// NewBuffered constructs a BufferedStatter that writes into w, it starts the goroutine that flushes
+// the metrics every flushInterval, Close stops it.
+func NewBuffered(w io.Writer, flushInterval time.Duration, packetSize int, maxQueue int) *BufferedStatter {
+	s := &BufferedStatter{
+		w:          w,
+		packetSize: packetSize,
+		maxQueue:   maxQueue,
+		counters:   make(map[string]*counterUpdate),
+		gauges:     make(map[string]*gaugeUpdate),
+		deltas:     make(map[string]*deltaUpdate),
+		flushChan:  make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go s.run(flushInterval)
+	return s
+}
+
+func (s *BufferedStatter) run(flushInterval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.flushChan:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Counter aggregates the counter, it is sent as one line per bucket, sample rate and tags
+func (s *BufferedStatter) Counter(sampleRate float32, bucket string, n ...int) {
+	s.counter("", sampleRate, bucket, n...)
+}
+
+// Timing queues the timings
+func (s *BufferedStatter) Timing(sampleRate float32, bucket string, d ...time.Duration) {
+	s.timing("", sampleRate, bucket, d...)
+}
+
+// Gauge keeps the last value of the gauge
+func (s *BufferedStatter) Gauge(sampleRate float32, bucket string, v ...string) {
+	s.gauge("", sampleRate, bucket, v...)
+}
+
+// GaugeDelta aggregates the changes of the gauge, they are sent after the value of the gauge
+func (s *BufferedStatter) GaugeDelta(sampleRate float32, bucket string, d ...int) {
+	s.gaugeDelta("", sampleRate, bucket, d...)
+}
+
+// Histogram queues the values
+func (s *BufferedStatter) Histogram(sampleRate float32, bucket string, v ...float64) {
+	s.values("", sampleRate, bucket, "h", formatFloats(v))
+}
+
+// Distribution queues the values
+func (s *BufferedStatter) Distribution(sampleRate float32, bucket string, v ...float64) {
+	s.values("", sampleRate, bucket, "d", formatFloats(v))
+}
+
+// Set queues the values, the server counts the unique ones
+func (s *BufferedStatter) Set(sampleRate float32, bucket string, v ...string) {
+	s.values("", sampleRate, bucket, "s", v)
+}
+
+// WithTags returns a Statter that adds the tags to the metrics and shares the buffer
+func (s *BufferedStatter) WithTags(tags ...string) Statter {
+	return &bufferedTags{s: s, tags: appendTags("", tags)}
+}
+
+type bufferedTags struct {
+	s    *BufferedStatter
+	tags string
+}
+
+func (t *bufferedTags) Counter(sampleRate float32, bucket string, n ...int) {
+	t.s.counter(t.tags, sampleRate, bucket, n...)
+}
+
+func (t *bufferedTags) Timing(sampleRate float32, bucket string, d ...time.Duration) {
+	t.s.timing(t.tags, sampleRate, bucket, d...)
+}
+
+func (t *bufferedTags) Gauge(sampleRate float32, bucket string, v ...string) {
+	t.s.gauge(t.tags, sampleRate, bucket, v...)
+}
+
+func (t *bufferedTags) GaugeDelta(sampleRate float32, bucket string, d ...int) {
+	t.s.gaugeDelta(t.tags, sampleRate, bucket, d...)
+}
+
+func (t *bufferedTags) Histogram(sampleRate float32, bucket string, v ...float64) {
+	t.s.values(t.tags, sampleRate, bucket, "h", formatFloats(v))
+}
+
+func (t *bufferedTags) Distribution(sampleRate float32, bucket string, v ...float64) {
+	t.s.values(t.tags, sampleRate, bucket, "d", formatFloats(v))
+}
+
+func (t *bufferedTags) Set(sampleRate float32, bucket string, v ...string) {
+	t.s.values(t.tags, sampleRate, bucket, "s", v)
+}
+
+func (t *bufferedTags) WithTags(tags ...string) Statter {
+	return &bufferedTags{s: t.s, tags: appendTags(t.tags, tags)}
+}
+
+func (s *BufferedStatter) counter(tags string, sampleRate float32, bucket string, n ...int) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := bucket + samp.Suffix() + tags
+	for _, ni := range n {
+		u, ok := s.counters[key]
+		if ok {
+			u.n += ni
+			continue
+		}
+		u = &counterUpdate{bucket: bucket, n: ni, sampling: samp, tags: tags}
+		if s.enqueue(u) {
+			s.counters[key] = u
+		}
+	}
+}
+
+func (s *BufferedStatter) timing(tags string, sampleRate float32, bucket string, d ...time.Duration) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, di := range d {
+		u := &timingUpdate{bucket: bucket, ms: int(di.Nanoseconds() / 1e6), sampling: samp, tags: tags}
+		if s.enqueue(u) {
+			s.queue = append(s.queue, u)
+		}
+	}
+}
+
+func (s *BufferedStatter) gauge(tags string, sampleRate float32, bucket string, v ...string) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := bucket + tags
+	for _, vi := range v {
+		// the new value replaces the pending changes
+		if d, ok := s.deltas[key]; ok {
+			s.size -= len(d.Message())
+			delete(s.deltas, key)
+		}
+		u, ok := s.gauges[key]
+		if ok {
+			s.size += len(vi) - len(u.val)
+			u.val = vi
+			u.sampling = samp
+			continue
+		}
+		u = &gaugeUpdate{bucket: bucket, val: vi, sampling: samp, tags: tags}
+		if s.enqueue(u) {
+			s.gauges[key] = u
+		}
+	}
+}
+
+func (s *BufferedStatter) gaugeDelta(tags string, sampleRate float32, bucket string, d ...int) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := bucket + tags
+	for _, di := range d {
+		u, ok := s.deltas[key]
+		if ok {
+			u.delta += di
+			continue
+		}
+		u = &deltaUpdate{bucket: bucket, delta: di, sampling: samp, tags: tags}
+		if s.enqueue(u) {
+			s.deltas[key] = u
+		}
+	}
+}
+
+func (s *BufferedStatter) values(tags string, sampleRate float32, bucket string, kind string, v []string) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, vi := range v {
+		u := &valueUpdate{bucket: bucket, val: vi, kind: kind, sampling: samp, tags: tags}
+		if s.enqueue(u) {
+			s.queue = append(s.queue, u)
+		}
+	}
+}
+
+// enqueue accounts a new line, it returns false if the queue is full and the line is dropped.
+// It needs the lock.
+func (s *BufferedStatter) enqueue(u sendable) bool {
+	if len(s.counters)+len(s.gauges)+len(s.deltas)+len(s.queue) >= s.maxQueue {
+		atomic.AddInt64(&s.dropped, 1)
+		s.signalFlush()
+		return false
+	}
+	s.size += len(u.Message())
+	if s.size >= s.packetSize {
+		s.signalFlush()
+	}
+	return true
+}
+
+func (s *BufferedStatter) signalFlush() {
+	select {
+	case s.flushChan <- struct{}{}:
+	default:
+	}
+}
+
+// Flush sends all the pending metrics
+func (s *BufferedStatter) Flush() {
+	s.mutex.Lock()
+	msgs := make([]sendable, 0, len(s.counters)+len(s.gauges)+len(s.deltas)+len(s.queue))
+	for _, u := range s.counters {
+		msgs = append(msgs, u)
+	}
+	for _, u := range s.gauges {
+		msgs = append(msgs, u)
+	}
+	// after the gauges, the changes are applied to the new values
+	for _, u := range s.deltas {
+		msgs = append(msgs, u)
+	}
+	msgs = append(msgs, s.queue...)
+	s.counters = make(map[string]*counterUpdate)
+	s.gauges = make(map[string]*gaugeUpdate)
+	s.deltas = make(map[string]*deltaUpdate)
+	s.queue = nil
+	s.size = 0
+	s.mutex.Unlock()
+
+	if len(msgs) == 0 {
+		return
+	}
+	(&statsd{w: s.w}).publishSize(msgs, s.packetSize)
+}
+
+// Dropped returns the number of metrics dropped because the queue was full
+func (s *BufferedStatter) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close stops the flushing goroutine, flushes the pending metrics and closes the io.Writer if it is an io.Closer
+func (s *BufferedStatter) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+		s.Flush()
+		if closer, ok := s.w.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,139 @@
+package statsd
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packetWriter keeps every Write as a packet
+type packetWriter struct {
+	mutex   sync.Mutex
+	packets []string
+}
+
+func (w *packetWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.packets = append(w.packets, string(p))
+	return len(p), nil
+}
+
+func (w *packetWriter) Packets() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]string{}, w.packets...)
+}
+
+func (w *packetWriter) Lines() []string {
+	lines := []string{}
+	for _, p := range w.Packets() {
+		lines = append(lines, strings.Split(strings.TrimSuffix(p, "\n"), "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestBufferedStatter(t *testing.T) {
+	Convey("Buffered statter aggregates and batches the metrics", t, func() {
+		w := &packetWriter{}
+		s := NewBuffered(w, time.Hour, MTU_PACKET_SIZE, 100)
+
+		Convey("Counters are aggregated and gauges keep the last value", func() {
+			s.Counter(1.0, "a.success", 1)
+			s.Counter(1.0, "a.success", 1, 2)
+			s.Counter(1.0, "a.fail", 1)
+			s.Gauge(1.0, "a.open", "0")
+			s.Gauge(1.0, "a.open", "1")
+			s.Timing(1.0, "a.duration", 10*time.Millisecond, 20*time.Millisecond)
+			So(w.Packets(), ShouldBeEmpty)
+
+			So(s.Close(), ShouldBeNil)
+			So(w.Packets(), ShouldHaveLength, 1)
+			So(w.Lines(), ShouldResemble, []string{
+				"a.duration:10|ms",
+				"a.duration:20|ms",
+				"a.fail:1|c",
+				"a.open:1|g",
+				"a.success:4|c",
+			})
+		})
+
+		Convey("Counters with different tags are aggregated apart", func() {
+			s.WithTags("name:a").Counter(1.0, "success", 1)
+			s.WithTags("name:a").Counter(1.0, "success", 1)
+			s.WithTags("name:b").Counter(1.0, "success", 1)
+			s.WithTags("name:b").Gauge(1.0, "open", "1")
+			s.Counter(1.0, "success", 1)
+
+			So(s.Close(), ShouldBeNil)
+			So(w.Lines(), ShouldResemble, []string{
+				"open:1|g|#name:b",
+				"success:1|c",
+				"success:1|c|#name:b",
+				"success:2|c|#name:a",
+			})
+		})
+
+		Convey("Gauge deltas are aggregated and sent after the value of the gauge", func() {
+			s.GaugeDelta(1.0, "inFlight", 1, 1)
+			s.GaugeDelta(1.0, "inFlight", -3)
+			s.GaugeDelta(1.0, "queued", 2)
+			s.Gauge(1.0, "queued", "10")
+			s.GaugeDelta(1.0, "queued", 1)
+			s.Histogram(1.0, "size", 2)
+			s.Set(1.0, "users", "bob")
+
+			So(s.Close(), ShouldBeNil)
+			packet := w.Packets()[0]
+			So(strings.Index(packet, "queued:10|g"), ShouldBeLessThan, strings.Index(packet, "queued:+1|g"))
+			So(w.Lines(), ShouldResemble, []string{
+				"inFlight:-1|g",
+				"queued:+1|g",
+				"queued:10|g",
+				"size:2|h",
+				"users:bob|s",
+			})
+		})
+
+		Convey("Flushes on the interval", func() {
+			s := NewBuffered(w, 5*time.Millisecond, MTU_PACKET_SIZE, 100)
+			defer s.Close()
+			s.Counter(1.0, "a.success", 1)
+			time.Sleep(50 * time.Millisecond)
+			So(w.Lines(), ShouldResemble, []string{"a.success:1|c"})
+		})
+
+		Convey("Flushes when there is a full packet, in packets no larger than the size", func() {
+			s := NewBuffered(w, time.Hour, 64, 100)
+			defer s.Close()
+			for i := 0; i < 10; i++ {
+				s.Timing(1.0, "a.duration", time.Duration(i)*time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			packets := w.Packets()
+			So(len(packets), ShouldBeGreaterThan, 1)
+			for _, p := range packets {
+				So(len(p), ShouldBeLessThanOrEqualTo, 64)
+			}
+		})
+
+		Convey("Drops the metrics when the queue is full", func() {
+			s := NewBuffered(&blockedWriter{}, time.Hour, MTU_PACKET_SIZE, 2)
+			s.Counter(1.0, "a", 1)
+			s.Counter(1.0, "b", 1)
+			s.Counter(1.0, "c", 1)
+			s.Counter(1.0, "a", 1)
+			So(s.Dropped(), ShouldEqual, 1)
+		})
+	})
+}
+
+type blockedWriter struct{}
+
+func (blockedWriter) Write(p []byte) (int, error) {
+	select {}
+}
@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff  = 100 * time.Millisecond
+	maxBackoff  = 10 * time.Second
+	dialTimeout = 2 * time.Second
+)
+
+var (
+	// ErrNotConnected is returned by the writes while the Conn waits to dial again, the metrics are dropped
+	ErrNotConnected = errors.New("statsd: not connected")
+	ErrClosed       = errors.New("statsd: connection closed")
+)
+
+// Conn is a io.Writer for the statsd server that manages the connection, it dials
+// when it is needed and after an error it dials again with exponential backoff.
+// Over the stream protocols (tcp, unix) every packet ends with a newline.
+type Conn struct {
+	proto    string
+	endpoint string
+	stream   bool
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	backoff  time.Duration
+	nextDial time.Time
+	closed   bool
+}
+
+// NewConn returns a Conn for "udp", "tcp", "unix" or "unixgram" (and their variants like "tcp4"),
+// it doesn't dial until the first write, so the server can be started after the service.
+func NewConn(proto, endpoint string) (*Conn, error) {
+	c := &Conn{
+		proto:      proto,
+		endpoint:   endpoint,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+	switch {
+	case strings.HasPrefix(proto, "udp"), proto == "unixgram":
+	case strings.HasPrefix(proto, "tcp"), proto == "unix":
+		c.stream = true
+	default:
+		return nil, fmt.Errorf("statsd: unsupported protocol %q", proto)
+	}
+	return c, nil
+}
+
+// ParseAddress splits addresses like "tcp://host:8125" or "unixgram:///var/run/statsd.sock"
+// in the protocol and the endpoint, the addresses without protocol are "udp"
+func ParseAddress(address string) (proto, endpoint string) {
+	if i := strings.Index(address, "://"); i >= 0 {
+		return address[:i], address[i+3:]
+	}
+	return "udp", address
+}
+
+// Connect dials now, it returns the error of the dial
+func (c *Conn) Connect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.conn != nil {
+		return nil
+	}
+	return c.dial(time.Now())
+}
+
+// dial needs the lock
+func (c *Conn) dial(now time.Time) error {
+	conn, err := net.DialTimeout(c.proto, c.endpoint, dialTimeout)
+	if err != nil {
+		c.fail(now)
+		return err
+	}
+	c.conn = conn
+	c.backoff = 0
+	return nil
+}
+
+// fail schedules the next dial, it needs the lock
+func (c *Conn) fail(now time.Time) {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.backoff == 0 {
+		c.backoff = c.minBackoff
+	} else {
+		c.backoff *= 2
+		if c.backoff > c.maxBackoff {
+			c.backoff = c.maxBackoff
+		}
+	}
+	c.nextDial = now.Add(c.backoff)
+}
+
+// Write sends a packet, dialing if there is no connection.
+// While it waits for the backoff it returns ErrNotConnected without dialing.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return 0, ErrClosed
+	}
+
+	now := time.Now()
+	if c.conn == nil {
+		if now.Before(c.nextDial) {
+			return 0, ErrNotConnected
+		}
+		if err := c.dial(now); err != nil {
+			return 0, err
+		}
+	}
+
+	buf := p
+	if c.stream && (len(p) == 0 || p[len(p)-1] != '\n') {
+		buf = append(append(make([]byte, 0, len(p)+1), p...), '\n')
+	}
+	c.conn.SetWriteDeadline(now.Add(dialTimeout))
+	n, err := c.conn.Write(buf)
+	if err != nil {
+		c.fail(now)
+		return 0, err
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	return n, nil
+}
+
+// Close closes the connection, the writes after Close return ErrClosed
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.conn != nil {
+		err := c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package statsd
+
+import (
+	"bufio"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lineServer accepts tcp connections and sends the lines that it reads
+type lineServer struct {
+	listener net.Listener
+	lines    chan string
+	conns    chan net.Conn
+}
+
+func newLineServer(address string) *lineServer {
+	l, err := net.Listen("tcp", address)
+	So(err, ShouldBeNil)
+	s := &lineServer{listener: l, lines: make(chan string, 100), conns: make(chan net.Conn, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *lineServer) next() string {
+	select {
+	case line := <-s.lines:
+		return line
+	case <-time.After(2 * time.Second):
+		return "timeout"
+	}
+}
+
+func freeAddress() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	address := l.Addr().String()
+	l.Close()
+	return address
+}
+
+// writeUntil writes the packet until there is no error, or returns the last error
+func writeUntil(c *Conn, p string) error {
+	var err error
+	for i := 0; i < 100; i++ {
+		if _, err = c.Write([]byte(p)); err == nil {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
+
+func TestConn(t *testing.T) {
+	Convey("Conn manages the connection with the statsd server", t, func() {
+
+		Convey("Over tcp every packet ends with a newline", func() {
+			server := newLineServer("127.0.0.1:0")
+			defer server.listener.Close()
+
+			client, err := Dial("tcp", server.listener.Addr().String())
+			So(err, ShouldBeNil)
+			client.Counter(1.0, "a", 1)
+			client.Gauge(1.0, "b", "2")
+
+			So(server.next(), ShouldEqual, "a:1|c")
+			So(server.next(), ShouldEqual, "b:2|g")
+		})
+
+		Convey("Dials again after the server closes the connection", func() {
+			server := newLineServer("127.0.0.1:0")
+			defer server.listener.Close()
+
+			c, err := NewConn("tcp", server.listener.Addr().String())
+			So(err, ShouldBeNil)
+			c.minBackoff = time.Millisecond
+			defer c.Close()
+
+			So(writeUntil(c, "a:1|c"), ShouldBeNil)
+			So(server.next(), ShouldEqual, "a:1|c")
+
+			(<-server.conns).Close()
+			// the first writes can succeed until the client sees the close
+			for i := 0; i < 100 && len(server.conns) == 0; i++ {
+				c.Write([]byte("b:1|c"))
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(len(server.conns), ShouldEqual, 1)
+			So(writeUntil(c, "c:1|c"), ShouldBeNil)
+			line := server.next()
+			for line == "b:1|c" {
+				line = server.next()
+			}
+			So(line, ShouldEqual, "c:1|c")
+		})
+
+		Convey("Lazy dialing works when the server starts later", func() {
+			address := freeAddress()
+			client, err := DialLazy("tcp", address)
+			So(err, ShouldBeNil)
+			c := client.(*statsd).w.(*Conn)
+			c.minBackoff = 20 * time.Millisecond
+
+			_, err = c.Write([]byte("a:1|c"))
+			So(err, ShouldNotBeNil)
+			_, err = c.Write([]byte("a:1|c"))
+			So(err, ShouldEqual, ErrNotConnected)
+
+			server := newLineServer(address)
+			defer server.listener.Close()
+			So(writeUntil(c, "a:1|c"), ShouldBeNil)
+			So(server.next(), ShouldEqual, "a:1|c")
+		})
+
+		Convey("The backoff grows up to the max", func() {
+			c, _ := NewConn("tcp", freeAddress())
+			c.minBackoff = time.Millisecond
+			c.maxBackoff = 4 * time.Millisecond
+			now := time.Now()
+			c.fail(now)
+			So(c.backoff, ShouldEqual, time.Millisecond)
+			c.fail(now)
+			So(c.backoff, ShouldEqual, 2*time.Millisecond)
+			c.fail(now)
+			c.fail(now)
+			So(c.backoff, ShouldEqual, 4*time.Millisecond)
+			So(c.nextDial, ShouldResemble, now.Add(4*time.Millisecond))
+		})
+
+		Convey("Over unixgram every packet is a datagram", func() {
+			dir, err := ioutil.TempDir("", "statsd")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "statsd.sock")
+			server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+			So(err, ShouldBeNil)
+			defer server.Close()
+
+			client, err := Dial("unixgram", path)
+			So(err, ShouldBeNil)
+			client.Counter(1.0, "a", 1, 2)
+
+			buf := make([]byte, 1024)
+			server.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, err := server.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "a:1|c\na:2|c")
+		})
+
+		Convey("Writes after Close fail", func() {
+			c, _ := NewConn("udp", "127.0.0.1:8125")
+			So(c.Close(), ShouldBeNil)
+			_, err := c.Write([]byte("a:1|c"))
+			So(err, ShouldEqual, ErrClosed)
+		})
+
+		Convey("Unsupported protocols are an error", func() {
+			_, err := NewConn("ip", "127.0.0.1")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ParseAddress splits the protocol", func() {
+			proto, endpoint := ParseAddress("tcp://localhost:8125")
+			So(proto, ShouldEqual, "tcp")
+			So(endpoint, ShouldEqual, "localhost:8125")
+			proto, endpoint = ParseAddress("unixgram:///var/run/statsd.sock")
+			So(proto, ShouldEqual, "unixgram")
+			So(endpoint, ShouldEqual, "/var/run/statsd.sock")
+			proto, endpoint = ParseAddress("localhost:8125")
+			So(proto, ShouldEqual, "udp")
+			So(endpoint, ShouldEqual, "localhost:8125")
+		})
+	})
+}
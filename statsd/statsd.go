@@ -5,7 +5,8 @@ import (
 	"io"
 	"log"
 	"math/rand"
-	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,19 +18,44 @@ type Statter interface {
 	Counter(sampleRate float32, bucket string, n ...int)
 	Timing(sampleRate float32, bucket string, d ...time.Duration)
 	Gauge(sampleRate float32, bucket string, value ...string)
+	// GaugeDelta changes the value of the gauge by d, "+N|g" or "-N|g"
+	GaugeDelta(sampleRate float32, bucket string, d ...int)
+	// Histogram sends the values to be aggregated by the server, "|h"
+	Histogram(sampleRate float32, bucket string, v ...float64)
+	// Distribution sends the values for a global distribution, "|d"
+	Distribution(sampleRate float32, bucket string, v ...float64)
+	// Set counts the unique values, "|s"
+	Set(sampleRate float32, bucket string, v ...string)
+	// WithTags returns a Statter that adds the DogStatsD tags (like "group:db") to every metric
+	WithTags(tags ...string) Statter
 }
 
 type statsd struct {
-	w io.Writer
+	w    io.Writer
+	tags string
 }
 
 // Dial takes the same parameters as net.Dial, ie. a transport protocol
-// (typically "udp") and an endpoint. It returns a new Statsd structure,
+// ("udp", "tcp", "unix" or "unixgram") and an endpoint. It returns a new Statsd structure,
 // ready to use.
 //
-// Note that g2s currently performs no management on the connection it creates.
+// It returns the error if the first dial fails, after that the connection is a Conn
+// that dials again when there are errors.
 func Dial(proto, endpoint string) (Statter, error) {
-	c, err := net.DialTimeout(proto, endpoint, 2*time.Second)
+	c, err := NewConn(proto, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	return New(c)
+}
+
+// DialLazy is Dial without dialing, it dials in the first metric,
+// so the statsd server can be started after the service
+func DialLazy(proto, endpoint string) (Statter, error) {
+	c, err := NewConn(proto, endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +76,18 @@ func New(w io.Writer) (Statter, error) {
 	}, nil
 }
 
+func (s *statsd) WithTags(tags ...string) Statter {
+	return &statsd{w: s.w, tags: appendTags(s.tags, tags)}
+}
+
+// Close closes the io.Writer if it is an io.Closer, like the net.Conn created by Dial
+func (s *statsd) Close() error {
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // bufferize folds the slice of sendables into a slice of byte-buffers,
 // each of which shall be no larger than max bytes.
 func bufferize(sendables []sendable, max int) [][]byte {
@@ -58,7 +96,7 @@ func bufferize(sendables []sendable, max int) [][]byte {
 
 	for _, sendable := range sendables {
 		buf := []byte(sendable.Message())
-		if b1sz+len(buf) > max {
+		if b1sz > 0 && b1sz+len(buf) > max {
 			bN = append(bN, b1)
 			b1, b1sz = []byte{}, 0
 		}
@@ -77,12 +115,21 @@ func bufferize(sendables []sendable, max int) [][]byte {
 // will be no larger than MAX_PACKET_SIZE. It then writes them, one by one,
 // into the Statsd io.Writer.
 func (s *statsd) publish(msgs []sendable) {
-	for _, buf := range bufferize(msgs, MAX_PACKET_SIZE) {
+	s.publishSize(msgs, MAX_PACKET_SIZE)
+}
+
+// publishSize is publish with packets no larger than max
+func (s *statsd) publishSize(msgs []sendable, max int) {
+	for _, buf := range bufferize(msgs, max) {
 		// In the base case, when the Statsd struct is backed by a net.Conn,
 		// "Multiple goroutines may invoke methods on a Conn simultaneously."
 		//   -- http://golang.org/pkg/net/#Conn
 		// Otherwise, Bring Your Own Synchronization™.
 		n, err := s.w.Write(buf)
+		if err == ErrNotConnected {
+			// the Conn is waiting to dial again, the error was already logged
+			continue
+		}
 		if err != nil {
 			log.Printf("g2s: publish: %s", err)
 		} else if n != len(buf) {
@@ -129,6 +176,7 @@ func (s *statsd) Counter(sampleRate float32, bucket string, n ...int) {
 			bucket:   bucket,
 			n:        ni,
 			sampling: samp,
+			tags:     s.tags,
 		}
 	}
 
@@ -152,6 +200,7 @@ func (s *statsd) Timing(sampleRate float32, bucket string, d ...time.Duration) {
 			bucket:   bucket,
 			ms:       int(di.Nanoseconds() / 1e6),
 			sampling: samp,
+			tags:     s.tags,
 		}
 	}
 
@@ -175,12 +224,76 @@ func (s *statsd) Gauge(sampleRate float32, bucket string, v ...string) {
 			bucket:   bucket,
 			val:      vi,
 			sampling: samp,
+			tags:     s.tags,
+		}
+	}
+
+	s.publish(msgs)
+}
+
+// GaugeDelta sends one or more changes of a gauge to statsd.
+func (s *statsd) GaugeDelta(sampleRate float32, bucket string, d ...int) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	msgs := make([]sendable, len(d))
+	for i, di := range d {
+		msgs[i] = &deltaUpdate{
+			bucket:   bucket,
+			delta:    di,
+			sampling: samp,
+			tags:     s.tags,
 		}
 	}
 
 	s.publish(msgs)
 }
 
+// Histogram sends one or more histogram values to statsd.
+func (s *statsd) Histogram(sampleRate float32, bucket string, v ...float64) {
+	s.values(sampleRate, bucket, "h", formatFloats(v))
+}
+
+// Distribution sends one or more distribution values to statsd.
+func (s *statsd) Distribution(sampleRate float32, bucket string, v ...float64) {
+	s.values(sampleRate, bucket, "d", formatFloats(v))
+}
+
+// Set sends one or more set values to statsd.
+func (s *statsd) Set(sampleRate float32, bucket string, v ...string) {
+	s.values(sampleRate, bucket, "s", v)
+}
+
+func (s *statsd) values(sampleRate float32, bucket string, kind string, v []string) {
+	samp, ok := maybeSample(sampleRate)
+	if !ok {
+		return
+	}
+
+	msgs := make([]sendable, len(v))
+	for i, vi := range v {
+		msgs[i] = &valueUpdate{
+			bucket:   bucket,
+			val:      vi,
+			kind:     kind,
+			sampling: samp,
+			tags:     s.tags,
+		}
+	}
+
+	s.publish(msgs)
+}
+
+func formatFloats(v []float64) []string {
+	values := make([]string, len(v))
+	for i, vi := range v {
+		values[i] = strconv.FormatFloat(vi, 'f', -1, 64)
+	}
+	return values
+}
+
 type sendable interface {
 	Message() string
 }
@@ -197,32 +310,76 @@ func (s *sampling) Suffix() string {
 	return ""
 }
 
+// tagReplacer removes the characters that break the DogStatsD line
+var tagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// appendTags adds the tags to the suffix of the line, "|#k:v,k2:v2"
+func appendTags(suffix string, tags []string) string {
+	for _, tag := range tags {
+		if suffix == "" {
+			suffix = "|#"
+		} else {
+			suffix += ","
+		}
+		suffix += tagReplacer.Replace(tag)
+	}
+	return suffix
+}
+
 type counterUpdate struct {
 	bucket string
 	n      int
 	sampling
+	tags string
 }
 
 func (u *counterUpdate) Message() string {
-	return fmt.Sprintf("%s:%d|c%s\n", u.bucket, u.n, u.sampling.Suffix())
+	return fmt.Sprintf("%s:%d|c%s%s\n", u.bucket, u.n, u.sampling.Suffix(), u.tags)
 }
 
 type timingUpdate struct {
 	bucket string
 	ms     int
 	sampling
+	tags string
 }
 
 func (u *timingUpdate) Message() string {
-	return fmt.Sprintf("%s:%d|ms%s\n", u.bucket, u.ms, u.sampling.Suffix())
+	return fmt.Sprintf("%s:%d|ms%s%s\n", u.bucket, u.ms, u.sampling.Suffix(), u.tags)
 }
 
 type gaugeUpdate struct {
 	bucket string
 	val    string
 	sampling
+	tags string
 }
 
 func (u *gaugeUpdate) Message() string {
-	return fmt.Sprintf("%s:%s|g%s\n", u.bucket, u.val, u.sampling.Suffix())
+	return fmt.Sprintf("%s:%s|g%s%s\n", u.bucket, u.val, u.sampling.Suffix(), u.tags)
+}
+
+type deltaUpdate struct {
+	bucket string
+	delta  int
+	sampling
+	tags string
+}
+
+// Message always has the sign, a value without it would set the gauge
+func (u *deltaUpdate) Message() string {
+	return fmt.Sprintf("%s:%+d|g%s%s\n", u.bucket, u.delta, u.sampling.Suffix(), u.tags)
+}
+
+// valueUpdate is a line of the types that are sent as they are, histograms, distributions and sets
+type valueUpdate struct {
+	bucket string
+	val    string
+	kind   string
+	sampling
+	tags string
+}
+
+func (u *valueUpdate) Message() string {
+	return fmt.Sprintf("%s:%s|%s%s%s\n", u.bucket, u.val, u.kind, u.sampling.Suffix(), u.tags)
 }
@@ -0,0 +1,86 @@
+package statsd
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	Convey("The tags are sent in DogStatsD format", t, func() {
+		w := &packetWriter{}
+		client, _ := New(w)
+
+		Convey("Without tags the lines are plain statsd", func() {
+			client.Counter(1.0, "a.success", 1)
+			So(w.Lines(), ShouldResemble, []string{"a.success:1|c"})
+		})
+
+		Convey("WithTags adds the tags to every metric", func() {
+			tagged := client.WithTags("group:db", "name:users")
+			tagged.Counter(1.0, "a.success", 1)
+			tagged.Timing(1.0, "a.duration", 10*time.Millisecond)
+			tagged.Gauge(1.0, "a.open", "0")
+			So(w.Lines(), ShouldResemble, []string{
+				"a.duration:10|ms|#group:db,name:users",
+				"a.open:0|g|#group:db,name:users",
+				"a.success:1|c|#group:db,name:users",
+			})
+		})
+
+		Convey("WithTags appends to the previous tags", func() {
+			client.WithTags("group:db").WithTags("name:users").Counter(1.0, "a", 1)
+			So(w.Lines(), ShouldResemble, []string{"a:1|c|#group:db,name:users"})
+		})
+
+		Convey("The tags are after the sample rate", func() {
+			client.WithTags("group:db").Counter(0.999999, "a", 1)
+			lines := w.Lines()
+			if len(lines) > 0 {
+				So(lines[0], ShouldEqual, "a:1|c|@0.999999|#group:db")
+			}
+		})
+
+		Convey("The characters that break the line are replaced", func() {
+			client.WithTags("name:a,b|c#d").Counter(1.0, "a", 1)
+			So(w.Lines(), ShouldResemble, []string{"a:1|c|#name:a_b_c_d"})
+		})
+	})
+}
+
+func TestMetricTypes(t *testing.T) {
+	Convey("The metric types have the statsd wire format", t, func() {
+		w := &packetWriter{}
+		client, _ := New(w)
+
+		Convey("Gauge deltas always have the sign", func() {
+			client.GaugeDelta(1.0, "a.inFlight", 3, -2, 0)
+			So(w.Packets(), ShouldResemble, []string{"a.inFlight:+3|g\na.inFlight:-2|g\na.inFlight:+0|g"})
+		})
+
+		Convey("Histograms, distributions and sets", func() {
+			client.Histogram(1.0, "a.size", 1.5, 20)
+			client.Distribution(1.0, "a.latency", 0.25)
+			client.Set(1.0, "a.users", "bob")
+			So(w.Lines(), ShouldResemble, []string{
+				"a.latency:0.25|d",
+				"a.size:1.5|h",
+				"a.size:20|h",
+				"a.users:bob|s",
+			})
+		})
+
+		Convey("The sample rate and the tags are the suffix", func() {
+			samp := sampling{enabled: true, rate: 0.5}
+			So((&valueUpdate{bucket: "a", val: "1", kind: "h", sampling: samp, tags: "|#k:v"}).Message(), ShouldEqual, "a:1|h|@0.500000|#k:v\n")
+			So((&deltaUpdate{bucket: "a", delta: -1, sampling: samp}).Message(), ShouldEqual, "a:-1|g|@0.500000\n")
+			So((&valueUpdate{bucket: "a", val: "x", kind: "s", sampling: samp}).Message(), ShouldEqual, "a:x|s|@0.500000\n")
+		})
+
+		Convey("Sampled out values are not sent", func() {
+			client.Histogram(0.0, "a.size", 1)
+			client.GaugeDelta(0.0, "a.inFlight", 1)
+			So(w.Packets(), ShouldBeEmpty)
+		})
+	})
+}
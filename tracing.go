@@ -0,0 +1,141 @@
+package goHystrix
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tracer starts the spans of the commands. Every Execute has a span "goHystrix.execute"
+// with the children "goHystrix.run" (the attempts of the command) and "goHystrix.fallback".
+// The spans have the attributes group, name, outcome, circuit.open and attempts.
+//
+// The context links a span with its parent, so an OpenTelemetry adapter is a thin wrapper
+// of otel's trace.Tracer.Start and trace.Span (see the README).
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttribute(key string, value interface{})
+	AddEvent(name string, attributes map[string]interface{})
+	End()
+}
+
+var (
+	tracer      Tracer = NilTracer{}
+	tracerMutex sync.RWMutex
+)
+
+// SetTracer sets the tracer of the commands, NilTracer (no spans) by default
+func SetTracer(t Tracer) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	if t == nil {
+		t = NilTracer{}
+	}
+	tracer = t
+}
+
+func CurrentTracer() Tracer {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+	return tracer
+}
+
+// NilTracer doesn't record anything
+type NilTracer struct{}
+
+func (NilTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nilSpan{}
+}
+
+type nilSpan struct{}
+
+func (nilSpan) SetAttribute(key string, value interface{})              {}
+func (nilSpan) AddEvent(name string, attributes map[string]interface{}) {}
+func (nilSpan) End()                                                    {}
+
+// RecordingTracer keeps the spans in memory, for tests
+type RecordingTracer struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+type RecordedSpan struct {
+	Name string
+	// Parent is nil for the root spans
+	Parent     *RecordedSpan
+	Attributes map[string]interface{}
+	Events     []RecordedEvent
+	StartTime  time.Time
+	EndTime    time.Time
+
+	tracer *RecordingTracer
+}
+
+type RecordedEvent struct {
+	Name       string
+	Attributes map[string]interface{}
+	Time       time.Time
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+type recordedSpanKey struct{}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	span := &RecordedSpan{
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]interface{}),
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+	t.mutex.Lock()
+	t.spans = append(t.spans, span)
+	t.mutex.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans returns the spans in the order they were started
+func (t *RecordingTracer) Spans() []*RecordedSpan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*RecordedSpan{}, t.spans...)
+}
+
+// Reset removes the recorded spans
+func (t *RecordingTracer) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.spans = nil
+}
+
+func (s *RecordedSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *RecordedSpan) AddEvent(name string, attributes map[string]interface{}) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.Events = append(s.Events, RecordedEvent{Name: name, Attributes: attributes, Time: time.Now()})
+}
+
+func (s *RecordedSpan) End() {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	s.EndTime = time.Now()
+}
+
+// Ended returns true if End was called
+func (s *RecordedSpan) Ended() bool {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+	return !s.EndTime.IsZero()
+}
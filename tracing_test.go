@@ -0,0 +1,102 @@
+package goHystrix
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestTracing(t *testing.T) {
+	Convey("The commands emit spans with the Tracer", t, func() {
+		CircuitsReset()
+		recorder := NewRecordingTracer()
+		SetTracer(recorder)
+		defer SetTracer(nil)
+
+		Convey("A successful call has the execute and the run spans", func() {
+			command := MustNewCommand("tracedCmd", "testGroup", &MyStringCommand{"hello"}, CommandOptionsForTest())
+			ctx, parent := recorder.Start(context.Background(), "request")
+			_, err := command.ExecuteContext(ctx)
+			So(err, ShouldBeNil)
+
+			spans := recorder.Spans()
+			So(spans, ShouldHaveLength, 3)
+			execute, run := spans[1], spans[2]
+			So(execute.Name, ShouldEqual, "goHystrix.execute")
+			So(execute.Parent, ShouldEqual, parent)
+			So(execute.Attributes["group"], ShouldEqual, "testGroup")
+			So(execute.Attributes["name"], ShouldEqual, "tracedCmd")
+			So(execute.Attributes["outcome"], ShouldEqual, "success")
+			So(execute.Attributes["circuit.open"], ShouldEqual, false)
+			So(execute.Ended(), ShouldBeTrue)
+
+			So(run.Name, ShouldEqual, "goHystrix.run")
+			So(run.Parent, ShouldEqual, execute)
+			So(run.Attributes["attempts"], ShouldEqual, 1)
+			So(run.Attributes["outcome"], ShouldEqual, "success")
+			So(run.Ended(), ShouldBeTrue)
+		})
+
+		Convey("A timeout is served by the fallback", func() {
+			command := NewStringCommand("timeout", "fallback")
+			result, err := command.Execute()
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "FALLBACK")
+
+			spans := recorder.Spans()
+			So(spans, ShouldHaveLength, 3)
+			So(spans[0].Attributes["outcome"], ShouldEqual, "timeout")
+			So(spans[1].Attributes["outcome"], ShouldEqual, "timeout")
+			fallback := spans[2]
+			So(fallback.Name, ShouldEqual, "goHystrix.fallback")
+			So(fallback.Parent, ShouldEqual, spans[0])
+			So(fallback.Attributes["outcome"], ShouldEqual, "success")
+			So(fallback.Attributes["error"], ShouldContainSubstring, "Timeout")
+			So(fallback.Ended(), ShouldBeTrue)
+		})
+
+		Convey("An open circuit short-circuits the call", func() {
+			command := NewStringCommand("error", "fallback")
+			for i := 0; i < 4; i++ {
+				command.Execute()
+			}
+			recorder.Reset()
+
+			_, err := command.Execute()
+			So(err, ShouldBeNil)
+
+			spans := recorder.Spans()
+			So(spans, ShouldHaveLength, 2)
+			So(spans[0].Attributes["outcome"], ShouldEqual, "short_circuited")
+			So(spans[0].Attributes["circuit.open"], ShouldEqual, true)
+			So(spans[1].Name, ShouldEqual, "goHystrix.fallback")
+			So(spans[1].Attributes["outcome"], ShouldEqual, "success")
+		})
+
+		Convey("The hedged attempts are events of the run span", func() {
+			options := CommandOptionsForTest()
+			options.Timeout = 100 * time.Millisecond
+			options.Hedge = HedgeOptions{Delay: 5 * time.Millisecond, MaxHedges: 1}
+			command := MustNewCommand("tracedHedgeCmd", "testGroup", &SlowFirstCommand{}, options)
+
+			_, err := command.Execute()
+			So(err, ShouldBeNil)
+
+			run := recorder.Spans()[1]
+			So(run.Attributes["attempts"], ShouldEqual, 2)
+			So(run.Events, ShouldHaveLength, 1)
+			So(run.Events[0].Name, ShouldEqual, "hedge")
+		})
+
+		Convey("Without fallback the fallback span is missing", func() {
+			command := MustNewCommand("tracedNoFallbackCmd", "testGroup", &ResultCommand{nil, ErrCircuitOpen, false}, CommandOptionsForTest())
+			_, err := command.Execute()
+			So(err, ShouldNotBeNil)
+
+			spans := recorder.Spans()
+			So(spans[0].Attributes["outcome"], ShouldEqual, "failure")
+			So(spans[2].Attributes["outcome"], ShouldEqual, "missing")
+		})
+	})
+}